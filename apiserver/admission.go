@@ -0,0 +1,106 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful"
+	"github.com/golang/glog"
+
+	"istio.io/manager/model"
+)
+
+// serviceKind is the admission object type for service declarations, which
+// are validated in addition to the configuration kinds in model.IstioConfig
+const serviceKind = "service"
+
+// AdmissionReview is a validation request and decision in the Kubernetes
+// admission review format. The request object holds a Config.
+type AdmissionReview struct {
+	Kind       string             `json:"kind,omitempty"`
+	APIVersion string             `json:"apiVersion,omitempty"`
+	Request    *AdmissionRequest  `json:"request,omitempty"`
+	Response   *AdmissionResponse `json:"response,omitempty"`
+}
+
+// AdmissionRequest is the object under review
+type AdmissionRequest struct {
+	UID    string          `json:"uid"`
+	Object json.RawMessage `json:"object"`
+}
+
+// AdmissionResponse is the admission decision, with the validation errors
+// of a denied object in the result message
+type AdmissionResponse struct {
+	UID     string           `json:"uid"`
+	Allowed bool             `json:"allowed"`
+	Result  *AdmissionStatus `json:"status,omitempty"`
+}
+
+// AdmissionStatus explains an admission decision
+type AdmissionStatus struct {
+	Message string `json:"message"`
+}
+
+// ReviewConfig validates the object in an admission review, and responds with
+// an allow or deny decision. Only malformed reviews fail with an error status.
+func (api *API) ReviewConfig(request *restful.Request, response *restful.Response) {
+	review := &AdmissionReview{}
+	if err := request.ReadEntity(review); err != nil {
+		api.writeError(http.StatusBadRequest, err.Error(), response)
+		return
+	}
+	if review.Request == nil {
+		api.writeError(http.StatusBadRequest, "missing admission request", response)
+		return
+	}
+
+	decision := &AdmissionResponse{UID: review.Request.UID, Allowed: true}
+	warnings, err := validateAdmission(review.Request.Object, api.kinds)
+	if err != nil {
+		glog.V(2).Infof("Denied admission of %s: %v", review.Request.UID, err)
+		decision.Allowed = false
+		decision.Result = &AdmissionStatus{Message: err.Error()}
+	}
+	for _, warning := range warnings {
+		glog.Warningf("Admission of %s: %s", review.Request.UID, warning)
+	}
+
+	out := AdmissionReview{Kind: review.Kind, APIVersion: review.APIVersion, Response: decision}
+	if err := response.WriteHeaderAndEntity(http.StatusOK, out); err != nil {
+		api.writeError(http.StatusInternalServerError, err.Error(), response)
+	}
+}
+
+// validateAdmission parses a config object and validates it with the
+// validation function of its kind. It also returns the warnings of the kind
+// for a config object.
+func validateAdmission(object json.RawMessage, kinds model.KindMap) ([]string, error) {
+	config := &Config{}
+	if err := json.Unmarshal(object, config); err != nil {
+		return nil, fmt.Errorf("cannot parse object: %v", err)
+	}
+
+	if config.Type == serviceKind {
+		spec, err := json.Marshal(config.Spec)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode Spec: %v", err)
+		}
+		service := &model.Service{}
+		if err = json.Unmarshal(spec, service); err != nil {
+			return nil, fmt.Errorf("cannot parse service: %v", err)
+		}
+		return nil, service.Validate()
+	}
+
+	schema, ok := kinds[config.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown spec type %s", config.Type)
+	}
+	if err := config.ParseSpec(); err != nil {
+		return nil, err
+	}
+	key := model.Key{Kind: config.Type, Name: config.Name}
+	return kinds.ConfigWarnings(&key, config.ParsedSpec), schema.Validate(config.ParsedSpec)
+}
@@ -23,6 +23,8 @@ type APIServiceOptions struct {
 	Version  string
 	Port     int
 	Registry *model.IstioRegistry
+	// Kinds validates configuration in admission reviews, defaulting to model.IstioConfig
+	Kinds model.KindMap
 }
 
 // API is the server wrapper that listens for incoming requests to the manager and processes them
@@ -30,6 +32,7 @@ type API struct {
 	server   *http.Server
 	version  string
 	registry *model.IstioRegistry
+	kinds    model.KindMap
 }
 
 // NewAPI creates a new instance of the API using the options passed to it
@@ -38,6 +41,10 @@ func NewAPI(o APIServiceOptions) *API {
 	out := &API{
 		version:  o.Version,
 		registry: o.Registry,
+		kinds:    o.Kinds,
+	}
+	if out.kinds == nil {
+		out.kinds = model.IstioConfig
 	}
 	container := restful.NewContainer()
 	out.Register(container)
@@ -87,6 +94,13 @@ func (api *API) Register(container *restful.Container) {
 		Doc("List all configs for kind in across all namespaces").
 		Writes([]Config{}))
 
+	ws.Route(ws.
+		POST("/admission").
+		To(api.ReviewConfig).
+		Doc("Validate a config or service in an admission review").
+		Reads(AdmissionReview{}).
+		Writes(AdmissionReview{}))
+
 	container.Add(ws)
 }
 
@@ -43,6 +43,7 @@ func makeAPIServer(r *model.IstioRegistry) *API {
 	return &API{
 		version:  "test",
 		registry: r,
+		kinds:    model.IstioConfig,
 	}
 }
 
@@ -259,6 +260,103 @@ func TestConfigErrors(t *testing.T) {
 	}
 }
 
+func TestReviewConfig(t *testing.T) {
+	validRuleJSON := []byte(`{"type":"route-rule","name":"name",` +
+		`"spec":{"destination":"service.namespace.svc.cluster.local","precedence":1,` +
+		`"route":[{"tags":{"version":"v1"},"weight":75},{"tags":{"version":"v2"},"weight":25}]}}`)
+	invalidRouteRuleJSON := []byte(`{"type":"route-rule","name":"name",` +
+		`"spec":{"destination":"service.namespace.svc.cluster.local",` +
+		`"route":[{"tags":{"version":"v1"},"weight":25},{"tags":{"version":"v2"},"weight":25}]}}`)
+	validPolicyJSON := []byte(`{"type":"destination-policy","name":"name",` +
+		`"spec":{"destination":"service.namespace.svc.cluster.local","tags":{"version":"v1"}}}`)
+	invalidPolicyJSON := []byte(`{"type":"destination-policy","name":"name","spec":{}}`)
+	validServiceJSON := []byte(`{"type":"service","name":"name",` +
+		`"spec":{"hostname":"service.namespace.svc.cluster.local","ports":[{"name":"http","port":80,"protocol":"HTTP"}]}}`)
+	invalidServiceJSON := []byte(`{"type":"service","name":"name",` +
+		`"spec":{"hostname":"service.namespace.svc.cluster.local","ports":[{"name":"http","port":-1,"protocol":"HTTP"}]}}`)
+	unknownTypeJSON := []byte(`{"type":"not-a-route-rule","name":"name","spec":{}}`)
+
+	cases := []struct {
+		name    string
+		object  []byte
+		allowed bool
+	}{
+		{name: "valid route rule", object: validRuleJSON, allowed: true},
+		{name: "invalid route rule", object: invalidRouteRuleJSON},
+		{name: "valid destination policy", object: validPolicyJSON, allowed: true},
+		{name: "invalid destination policy", object: invalidPolicyJSON},
+		{name: "valid service", object: validServiceJSON, allowed: true},
+		{name: "invalid service", object: invalidServiceJSON},
+		{name: "unknown type", object: unknownTypeJSON},
+	}
+	for _, c := range cases {
+		api := makeAPIServer(mock.MakeRegistry())
+		review, err := json.Marshal(AdmissionReview{
+			Kind:       "AdmissionReview",
+			APIVersion: "admission.k8s.io/v1beta1",
+			Request:    &AdmissionRequest{UID: c.name, Object: c.object},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, body := makeAPIRequest(api, "POST", "/test/admission", review, t)
+		compareStatus(status, http.StatusOK, t)
+		got := AdmissionReview{}
+		if err = json.Unmarshal(body, &got); err != nil {
+			t.Fatalf("%s: cannot parse admission review %q: %v", c.name, string(body), err)
+		}
+		if got.Response == nil || got.Response.UID != c.name {
+			t.Errorf("%s: got response %+v, want uid %q", c.name, got.Response, c.name)
+			continue
+		}
+		if got.Response.Allowed != c.allowed {
+			t.Errorf("%s: got allowed=%v, want %v: %+v", c.name, got.Response.Allowed, c.allowed, got.Response.Result)
+		}
+		if !c.allowed && (got.Response.Result == nil || got.Response.Result.Message == "") {
+			t.Errorf("%s: denied without a message", c.name)
+		}
+	}
+
+	status, _ := makeAPIRequest(makeAPIServer(mock.MakeRegistry()), "POST", "/test/admission", []byte(`{}`), t)
+	compareStatus(status, http.StatusBadRequest, t)
+}
+
+func TestReviewConfigOptions(t *testing.T) {
+	// the default options allow tag values of up to 63 characters
+	options := model.DefaultValidationOptions
+	options.MaxTagValueLength = 1
+	api := makeAPIServer(mock.MakeRegistry())
+	api.kinds = model.NewIstioConfig(options)
+	review, err := json.Marshal(AdmissionReview{
+		Request: &AdmissionRequest{UID: "policy", Object: []byte(`{"type":"destination-policy","name":"name",` +
+			`"spec":{"destination":"service.namespace.svc.cluster.local","tags":{"version":"v1"}}}`)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := makeAPIRequest(api, "POST", "/test/admission", review, t)
+	compareStatus(status, http.StatusOK, t)
+	got := AdmissionReview{}
+	if err = json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("cannot parse admission review %q: %v", string(body), err)
+	}
+	if got.Response == nil || got.Response.Allowed {
+		t.Errorf("got response %+v, want a denied tag value above the maximum length", got.Response)
+	}
+
+	// kinds left out of the API kinds are denied
+	api.kinds = model.KindMap{model.RouteRule: model.IstioConfig[model.RouteRule]}
+	status, body = makeAPIRequest(api, "POST", "/test/admission", review, t)
+	compareStatus(status, http.StatusOK, t)
+	got = AdmissionReview{}
+	if err = json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("cannot parse admission review %q: %v", string(body), err)
+	}
+	if got.Response == nil || got.Response.Allowed {
+		t.Errorf("got response %+v, want a denied kind missing from the API kinds", got.Response)
+	}
+}
+
 func compareListCount(body []byte, expected int, t *testing.T) {
 	configSlice := []Config{}
 	if err := json.Unmarshal(body, &configSlice); err != nil {
@@ -15,8 +15,10 @@
 package cmd
 
 import (
+	"expvar"
 	"flag"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
@@ -86,3 +88,18 @@ func WaitSignal(stop chan struct{}) {
 	close(stop)
 	glog.Flush()
 }
+
+// ServeDebugVars serves the expvar metrics on the port at /debug/vars in the
+// background. A zero port disables the endpoint.
+func ServeDebugVars(port int) {
+	if port == 0 {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	go func() {
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+			glog.Warningf("Debug endpoint on port %d stopped: %v", port, err)
+		}
+	}()
+}
@@ -15,6 +15,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"time"
@@ -41,6 +42,7 @@ type args struct {
 
 	ipAddress                string
 	podName                  string
+	debugPort                int
 	sdsPort                  int
 	apiserverPort            int
 	ingressSecret            string
@@ -48,6 +50,16 @@ type args struct {
 	defaultIngressController bool
 	enableProfiling          bool
 	enableDiscoveryCaching   bool
+	discoveryCacheTTL        time.Duration
+	discoveryCacheSize       int
+	registryTimeout          time.Duration
+	checkTimeout             time.Duration
+	discoveryCompression     bool
+	sdsFormat                string
+	sdsSocket                string
+	scopeServices            bool
+	proxy                    envoy.WatcherOptions
+	validation               model.ValidationOptions
 }
 
 var (
@@ -60,7 +72,7 @@ var (
 		Short: "Istio Manager",
 		Long:  "Istio Manager provides management plane functionality to the Istio proxy mesh and Istio Mixer.",
 		PersistentPreRunE: func(*cobra.Command, []string) (err error) {
-			client, err = kube.NewClient(flags.kubeconfig, model.IstioConfig)
+			client, err = kube.NewClient(flags.kubeconfig, model.NewIstioConfig(flags.validation))
 			if err != nil {
 				return multierror.Prefix(err, "failed to connect to Kubernetes API.")
 			}
@@ -106,10 +118,17 @@ var (
 				Config: &model.IstioRegistry{
 					ConfigRegistry: controller,
 				},
-				Mesh:            mesh,
-				Port:            flags.sdsPort,
-				EnableProfiling: flags.enableProfiling,
-				EnableCaching:   flags.enableDiscoveryCaching,
+				Mesh:              mesh,
+				Port:              flags.sdsPort,
+				EnableProfiling:   flags.enableProfiling,
+				EnableCaching:     flags.enableDiscoveryCaching,
+				SDSFormat:         flags.sdsFormat,
+				UnixSocket:        flags.sdsSocket,
+				ScopeServices:     flags.scopeServices,
+				CacheTTL:          flags.discoveryCacheTTL,
+				CacheMaxEntries:   flags.discoveryCacheSize,
+				RegistryTimeout:   flags.registryTimeout,
+				EnableCompression: flags.discoveryCompression,
 			}
 			sds, err := envoy.NewDiscoveryService(options)
 			if err != nil {
@@ -121,16 +140,59 @@ var (
 				Registry: &model.IstioRegistry{
 					ConfigRegistry: controller,
 				},
+				Kinds: model.NewIstioConfig(flags.validation),
 			})
 			stop := make(chan struct{})
 			go controller.Run(stop)
 			go sds.Run()
 			go apiserver.Run()
 			cmd.WaitSignal(stop)
+			sds.Stop()
+			if err := sds.Close(); err != nil {
+				glog.Warning(err)
+			}
 			return
 		},
 	}
 
+	checkCmd = &cobra.Command{
+		Use:   "check",
+		Short: "Check the consistency of services, instances, and configuration",
+		RunE: func(c *cobra.Command, args []string) error {
+			controller := kube.NewController(client, kube.ControllerConfig{
+				Namespace:       flags.namespace,
+				ResyncPeriod:    flags.resyncPeriod,
+				IngressSyncMode: kube.IngressOff,
+			})
+			stop := make(chan struct{})
+			defer close(stop)
+			go controller.Run(stop)
+			select {
+			case <-controller.Ready():
+			case <-time.After(flags.checkTimeout):
+				return fmt.Errorf("controller did not synchronize within %v", flags.checkTimeout)
+			}
+
+			registry := &model.IstioRegistry{ConfigRegistry: controller}
+			report := registry.Check(model.NewIstioConfig(flags.validation), controller)
+			out, err := json.MarshalIndent(report, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			invalid := 0
+			for _, result := range report {
+				if !result.Valid {
+					invalid++
+				}
+			}
+			if invalid > 0 {
+				return fmt.Errorf("%d of %d checked objects are invalid", invalid, len(report))
+			}
+			return nil
+		},
+	}
+
 	proxyCmd = &cobra.Command{
 		Use:   "proxy",
 		Short: "Istio Proxy agent",
@@ -149,10 +211,12 @@ var (
 				controller,
 				&model.IstioRegistry{ConfigRegistry: controller},
 				mesh,
-				flags.ipAddress)
+				flags.ipAddress,
+				flags.proxy)
 			if err != nil {
 				return
 			}
+			cmd.ServeDebugVars(flags.debugPort)
 			stop := make(chan struct{})
 			go w.Run(stop)
 			cmd.WaitSignal(stop)
@@ -179,11 +243,13 @@ var (
 				Secrets:   client,
 				Registry:  &model.IstioRegistry{ConfigRegistry: controller},
 				Mesh:      mesh,
+				Discovery: controller,
 			}
-			w, err := envoy.NewIngressWatcher(controller, config)
+			w, err := envoy.NewIngressWatcher(controller, config, flags.proxy)
 			if err != nil {
 				return err
 			}
+			cmd.ServeDebugVars(flags.debugPort)
 			stop := make(chan struct{})
 			go w.Run(stop)
 			cmd.WaitSignal(stop)
@@ -213,19 +279,68 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&flags.config, "meshConfig", cmd.DefaultConfigMapName,
 		fmt.Sprintf("ConfigMap name for Istio mesh configuration, key should be %q", cmd.ConfigMapKey))
 
+	validation := model.DefaultValidationOptions
+	rootCmd.PersistentFlags().StringSliceVar(&flags.validation.ProtectedServices, "protectedServices",
+		validation.ProtectedServices, "Services that route rules cannot inject faults into")
+	rootCmd.PersistentFlags().Float64Var(&flags.validation.MaxFaultDelaySeconds, "maxFaultDelay",
+		validation.MaxFaultDelaySeconds, "Fault delay in seconds above which route rule validation warns")
+	rootCmd.PersistentFlags().BoolVar(&flags.validation.RequireQualifiedDestinations, "requireQualifiedDestinations",
+		validation.RequireQualifiedDestinations,
+		"Reject route rules with destinations that are not fully qualified host names")
+	rootCmd.PersistentFlags().IntVar(&flags.validation.MaxTagKeyLength, "maxTagKeyLength",
+		validation.MaxTagKeyLength, "Longest tag key accepted by configuration validation")
+	rootCmd.PersistentFlags().IntVar(&flags.validation.MaxTagValueLength, "maxTagValueLength",
+		validation.MaxTagValueLength, "Longest tag value accepted by configuration validation")
+
 	discoveryCmd.PersistentFlags().IntVarP(&flags.sdsPort, "sdsPort", "p", 8080,
 		"Discovery service port")
 	discoveryCmd.PersistentFlags().IntVar(&flags.apiserverPort, "apiPort", 8081,
 		"API service port")
 	discoveryCmd.PersistentFlags().BoolVar(&flags.enableProfiling, "profile", true,
-		"Enable profiling via web interface host:port/debug/pprof")
+		"Enable profiling via web interface host:port/debug/pprof, raw cluster views, and node draining")
 	discoveryCmd.PersistentFlags().BoolVar(&flags.enableDiscoveryCaching, "discovery_cache", true,
 		"Enable caching discovery service responses")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.discoveryCacheTTL, "discovery_cache_ttl", 0,
+		"Expire cached discovery service responses after this duration (0 keeps them until a registry change)")
+	discoveryCmd.PersistentFlags().IntVar(&flags.discoveryCacheSize, "discovery_cache_size", 0,
+		"Maximum number of cached responses per discovery service type, evicting the least recently used (0 for no limit)")
+	discoveryCmd.PersistentFlags().DurationVar(&flags.registryTimeout, "registry_timeout", 0,
+		"Fail discovery requests with 503 if computing the response from the service registry takes longer "+
+			"than this duration (0 waits forever)")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.discoveryCompression, "discovery_compression", false,
+		"Compress large discovery service responses for clients accepting gzip")
+	discoveryCmd.PersistentFlags().StringVar(&flags.sdsFormat, "sdsFormat", envoy.SDSFormatV1,
+		fmt.Sprintf("Default SDS response format, %q or %q", envoy.SDSFormatV1, envoy.SDSFormatV2))
+	discoveryCmd.PersistentFlags().StringVar(&flags.sdsSocket, "sdsSocket", "",
+		"Unix domain socket path for the discovery service, in addition to sdsPort (set sdsPort to 0 to disable TCP)")
+	discoveryCmd.PersistentFlags().BoolVar(&flags.scopeServices, "scopeServices", false,
+		"Limit clusters and routes for a proxy to the services referenced by its route rules")
+
+	checkCmd.PersistentFlags().DurationVar(&flags.checkTimeout, "timeout", 30*time.Second,
+		"Maximum time to wait for the controller to synchronize before checking")
 
 	proxyCmd.PersistentFlags().StringVar(&flags.ipAddress, "ipAddress", "",
 		"IP address. If not provided uses ${POD_IP} environment variable.")
 	proxyCmd.PersistentFlags().StringVar(&flags.podName, "podName", "",
 		"Pod name. If not provided uses ${POD_NAME} environment variable")
+	proxyCmd.PersistentFlags().IntVar(&flags.debugPort, "debugPort", 15003,
+		"Port serving the proxy agent metrics at /debug/vars, disabled if 0")
+
+	proxyCmd.PersistentFlags().IntVar(&flags.proxy.MaxRetries, "maxRetries", envoy.DefaultMaxRetries,
+		"Maximum number of attempts to restart the proxy with a new configuration")
+	proxyCmd.PersistentFlags().DurationVar(&flags.proxy.RetryInterval, "retryInterval", envoy.DefaultRetryInterval,
+		"Delay before the first proxy restart attempt, doubled on each retry")
+	proxyCmd.PersistentFlags().BoolVar(&flags.proxy.DryRun, "dryRun", false,
+		"Write the generated proxy configuration to stdout instead of starting the proxy")
+	proxyCmd.PersistentFlags().StringVar(&flags.proxy.LogFormat, "envoyLogFormat", "",
+		"Envoy log format, using the Envoy default format if empty")
+	proxyCmd.PersistentFlags().StringVar(&flags.proxy.LogPath, "envoyLogPath", "",
+		"Absolute path of the file Envoy writes its logs to, using stderr if empty")
+	proxyCmd.PersistentFlags().IntVar(&flags.proxy.Concurrency, "concurrency", 0,
+		"Number of Envoy worker threads, using one per hardware thread if 0")
+
+	sidecarCmd.PersistentFlags().BoolVar(&flags.proxy.Static, "staticConfig", false,
+		"Embed all clusters and routes in the proxy configuration instead of using discovery")
 
 	// TODO: remove this once we write the logic to obtain secrets dynamically
 	ingressCmd.PersistentFlags().StringVar(&flags.ingressSecret, "secret", "",
@@ -243,6 +358,7 @@ func init() {
 	cmd.AddFlags(rootCmd)
 
 	rootCmd.AddCommand(discoveryCmd)
+	rootCmd.AddCommand(checkCmd)
 	rootCmd.AddCommand(proxyCmd)
 	rootCmd.AddCommand(version.VersionCmd)
 }
@@ -89,6 +89,9 @@ type ProtoSchema struct {
 	MessageName string
 	// Validate configuration as a protobuf message
 	Validate func(o proto.Message) error
+	// Warnings lists the valid but likely misconfigured settings of a
+	// configuration protobuf message (optional)
+	Warnings func(o proto.Message) []string
 	// Internal flag indicates that the configuration type is derived
 	// from other configuration sources. This prohibits direct updates
 	// but allows listing and watching.
@@ -124,22 +127,31 @@ const (
 
 var (
 	// IstioConfig lists all Istio config kinds with schemas and validation
-	IstioConfig = KindMap{
+	IstioConfig = NewIstioConfig(DefaultValidationOptions)
+)
+
+// NewIstioConfig lists all Istio config kinds with schemas and validation
+// using the validation options
+func NewIstioConfig(o ValidationOptions) KindMap {
+	return KindMap{
 		RouteRule: ProtoSchema{
 			MessageName: RouteRuleProto,
-			Validate:    ValidateRouteRule,
+			Validate:    o.ValidateRouteRule,
+			Warnings:    o.RouteRuleWarnings,
 		},
 		IngressRule: ProtoSchema{
 			MessageName: IngressRuleProto,
-			Validate:    ValidateIngressRule,
+			Validate:    o.ValidateIngressRule,
+			Warnings:    o.IngressRuleWarnings,
 			Internal:    true,
 		},
 		DestinationPolicy: ProtoSchema{
 			MessageName: DestinationPolicyProto,
-			Validate:    ValidateDestinationPolicy,
+			Validate:    o.ValidateDestinationPolicy,
+			Warnings:    DestinationPolicyWarnings,
 		},
 	}
-)
+}
 
 // IstioRegistry provides a simple adapter for Istio configuration kinds
 type IstioRegistry struct {
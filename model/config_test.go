@@ -15,6 +15,7 @@
 package model
 
 import (
+	"encoding/json"
 	"errors"
 	"reflect"
 	"strings"
@@ -23,6 +24,7 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/golang/mock/gomock"
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 )
@@ -736,6 +738,31 @@ func TestServiceInstanceValidate(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "invalid endpoint SNI",
+			instance: &ServiceInstance{
+				Service: service1,
+				Endpoint: NetworkEndpoint{
+					Address:     "192.168.1.1",
+					Port:        10001,
+					ServicePort: service1.Ports[0],
+					SNI:         "tenant_a..example.com",
+				},
+			},
+		},
+		{
+			name: "endpoint SNI",
+			instance: &ServiceInstance{
+				Service: service1,
+				Endpoint: NetworkEndpoint{
+					Address:     "192.168.1.1",
+					Port:        10001,
+					ServicePort: service1.Ports[0],
+					SNI:         "tenant-a.example.com",
+				},
+			},
+			valid: true,
+		},
 	}
 	for _, c := range cases {
 		if got := c.instance.Validate(); (got == nil) != c.valid {
@@ -770,6 +797,15 @@ func TestServiceValidate(t *testing.T) {
 			name:    "invalid hostname",
 			service: &Service{Hostname: "hostname.^.com", Address: address, Ports: ports},
 		},
+		{
+			name:    "unicode hostname",
+			service: &Service{Hostname: "bücher.example.com", Address: address, Ports: ports},
+		},
+		{
+			name:    "punycode hostname",
+			service: &Service{Hostname: "xn--bcher-kva.example.com", Address: address, Ports: ports},
+			valid:   true,
+		},
 		{
 			name:    "empty ports",
 			service: &Service{Hostname: "hostname", Address: address},
@@ -778,6 +814,49 @@ func TestServiceValidate(t *testing.T) {
 			name:    "bad ports",
 			service: &Service{Hostname: "hostname", Address: address, Ports: badPorts},
 		},
+		{
+			name:    "wildcard hostname",
+			service: &Service{Hostname: "*.example.com", Ports: ports},
+		},
+		{
+			name:    "external wildcard hostname",
+			service: &Service{Hostname: "*.example.com", ExternalName: "www.example.com", Ports: ports},
+			valid:   true,
+		},
+		{
+			name:    "interior wildcard hostname",
+			service: &Service{Hostname: "foo.*.com", ExternalName: "www.example.com", Ports: ports},
+		},
+		{
+			name:    "partial wildcard label",
+			service: &Service{Hostname: "*foo.example.com", Ports: ports},
+		},
+		{
+			name:    "wildcard only hostname",
+			service: &Service{Hostname: "*", Ports: ports},
+		},
+		{
+			name:    "empty interior label",
+			service: &Service{Hostname: "*..com", Ports: ports},
+		},
+		{
+			name:    "external name",
+			service: &Service{Hostname: "google", ExternalName: "www.google.com", Ports: ports},
+			valid:   true,
+		},
+		{
+			name:    "invalid external name",
+			service: &Service{Hostname: "google", ExternalName: "www.^.com", Ports: ports},
+		},
+		{
+			name:    "external name with address",
+			service: &Service{Hostname: "google", ExternalName: "www.google.com", Address: address, Ports: ports},
+		},
+		{
+			name: "external name without port",
+			service: &Service{Hostname: "google", ExternalName: "www.google.com",
+				Ports: PortList{{Name: "http", Protocol: ProtocolHTTP}}},
+		},
 	}
 	for _, c := range cases {
 		if got := c.service.Validate(); (got == nil) != c.valid {
@@ -786,6 +865,41 @@ func TestServiceValidate(t *testing.T) {
 	}
 }
 
+func TestValidateFQDNPunycode(t *testing.T) {
+	err := validateFQDN("bücher.example.com")
+	if err == nil || !strings.Contains(err.Error(), "punycode") {
+		t.Errorf("validateFQDN(unicode) => got %v, want punycode error", err)
+	}
+	if err := validateFQDN("xn--bcher-kva.example.com"); err != nil {
+		t.Errorf("validateFQDN(punycode) => unexpected error %v", err)
+	}
+}
+
+func TestValidateInstancePorts(t *testing.T) {
+	service := &Service{Hostname: "hello.default.svc.cluster.local"}
+	other := &Service{Hostname: "world.default.svc.cluster.local"}
+	http := &Port{Name: "http", Port: 80, Protocol: ProtocolHTTP}
+	tcp := &Port{Name: "tcp", Port: 90, Protocol: ProtocolTCP}
+	instance := func(svc *Service, port *Port) *ServiceInstance {
+		return &ServiceInstance{
+			Service:  svc,
+			Endpoint: NetworkEndpoint{Address: "10.1.1.1", Port: 8080, ServicePort: port},
+		}
+	}
+
+	consistent := []*ServiceInstance{instance(service, http), instance(other, http)}
+	if err := ValidateInstancePorts(consistent); err != nil {
+		t.Errorf("ValidateInstancePorts(consistent) => unexpected error %v", err)
+	}
+
+	conflicting := []*ServiceInstance{instance(service, http), instance(other, tcp)}
+	if err := ValidateInstancePorts(conflicting); err == nil {
+		t.Error("ValidateInstancePorts(conflicting) => expected error")
+	} else if !strings.Contains(err.Error(), "10.1.1.1:8080") {
+		t.Errorf("ValidateInstancePorts(conflicting) error should name the endpoint: %v", err)
+	}
+}
+
 func TestTagsValidate(t *testing.T) {
 	cases := []struct {
 		name  string
@@ -813,6 +927,312 @@ func TestTagsValidate(t *testing.T) {
 	}
 }
 
+func TestTagsValidateLength(t *testing.T) {
+	cases := []struct {
+		name  string
+		tags  Tags
+		valid bool
+	}{
+		{
+			name:  "short tags",
+			tags:  Tags{"version": "v1"},
+			valid: true,
+		},
+		{
+			name:  "max key length",
+			tags:  Tags{strings.Repeat("k", DefaultValidationOptions.MaxTagKeyLength): "v1"},
+			valid: true,
+		},
+		{
+			name: "key too long",
+			tags: Tags{strings.Repeat("k", DefaultValidationOptions.MaxTagKeyLength+1): "v1"},
+		},
+		{
+			name:  "max value length",
+			tags:  Tags{"version": strings.Repeat("v", DefaultValidationOptions.MaxTagValueLength)},
+			valid: true,
+		},
+		{
+			name: "value too long",
+			tags: Tags{"version": strings.Repeat("v", DefaultValidationOptions.MaxTagValueLength+1)},
+		},
+	}
+	for _, c := range cases {
+		if got := c.tags.Validate(); (got == nil) != c.valid {
+			t.Errorf("%s failed: got valid=%v but wanted valid=%v: %v", c.name, got == nil, c.valid, got)
+		}
+	}
+}
+
+func TestOverlappingRoutes(t *testing.T) {
+	disjoint := &proxyconfig.RouteRule{
+		Destination: "reviews",
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v1"}, Weight: 90},
+			{Tags: map[string]string{"version": "v2"}, Weight: 10},
+			{Destination: "ratings", Tags: map[string]string{"version": "v2"}},
+		},
+	}
+	if got := overlappingRoutes(disjoint); len(got) != 0 {
+		t.Errorf("overlappingRoutes(%v) => got %v, want none", disjoint, got)
+	}
+	if err := ValidateRouteRule(disjoint); err != nil {
+		t.Errorf("ValidateRouteRule(%v) => unexpected error %v", disjoint, err)
+	}
+
+	overlapping := &proxyconfig.RouteRule{
+		Destination: "reviews",
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v1"}, Weight: 90},
+			{Tags: map[string]string{"env": "prod"}, Weight: 10},
+		},
+	}
+	if got := overlappingRoutes(overlapping); len(got) != 1 {
+		t.Errorf("overlappingRoutes(%v) => got %v, want one overlap", overlapping, got)
+	}
+	if err := ValidateRouteRule(overlapping); err != nil {
+		t.Errorf("ValidateRouteRule(%v) should only warn: %v", overlapping, err)
+	}
+}
+
+func TestValidateDuplicateRoutes(t *testing.T) {
+	duplicate := &proxyconfig.RouteRule{
+		Destination: "reviews",
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v1"}, Weight: 50},
+			{Destination: "reviews", Tags: map[string]string{"version": "v1"}, Weight: 50},
+		},
+	}
+	if err := ValidateRouteRule(duplicate); err == nil {
+		t.Errorf("ValidateRouteRule(%v) => expected error", duplicate)
+	} else if !strings.Contains(err.Error(), "duplicate") {
+		t.Errorf("ValidateRouteRule(%v) error should mention the duplicate: %v", duplicate, err)
+	}
+	if got := overlappingRoutes(duplicate); len(got) != 0 {
+		t.Errorf("overlappingRoutes(%v) => got %v, want duplicates reported as errors only", duplicate, got)
+	}
+
+	distinct := &proxyconfig.RouteRule{
+		Destination: "reviews",
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v1"}, Weight: 50},
+			{Tags: map[string]string{"version": "v1", "env": "prod"}, Weight: 25},
+			{Destination: "ratings", Tags: map[string]string{"version": "v1"}, Weight: 25},
+		},
+	}
+	if err := ValidateRouteRule(distinct); err != nil {
+		t.Errorf("ValidateRouteRule(%v) => unexpected error %v", distinct, err)
+	}
+}
+
+func TestCircuitBreakerFootguns(t *testing.T) {
+	cases := []struct {
+		name     string
+		simple   *proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy
+		footguns int
+	}{
+		{
+			name: "sane",
+			simple: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{
+				MaxConnections:         100,
+				HttpMaxPendingRequests: 10,
+				HttpConsecutiveErrors:  5,
+				HttpMaxEjectionPercent: 50,
+			},
+		},
+		{
+			// unset thresholds fall back to Envoy defaults
+			name:   "zero pending requests",
+			simple: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{MaxConnections: 100},
+		},
+		{
+			name: "eject every host",
+			simple: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{
+				HttpConsecutiveErrors:  1,
+				HttpMaxEjectionPercent: 100,
+			},
+			footguns: 1,
+		},
+		{
+			name: "no connection reuse",
+			simple: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{
+				HttpMaxRequestsPerConnection: 1,
+			},
+			footguns: 1,
+		},
+	}
+	for _, c := range cases {
+		if got := circuitBreakerFootguns(c.simple); len(got) != c.footguns {
+			t.Errorf("%s: got footguns %v, want %d", c.name, got, c.footguns)
+		}
+		cb := &proxyconfig.CircuitBreaker{CbPolicy: &proxyconfig.CircuitBreaker_SimpleCb{SimpleCb: c.simple}}
+		if err := ValidateCircuitBreaker(cb); err != nil {
+			t.Errorf("%s: ValidateCircuitBreaker should only warn: %v", c.name, err)
+		}
+	}
+}
+
+func TestRequireQualifiedDestinations(t *testing.T) {
+	short := &proxyconfig.RouteRule{
+		Destination: "reviews",
+		Route:       []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}},
+	}
+	qualified := &proxyconfig.RouteRule{
+		Destination: "reviews.default.svc.cluster.local",
+		Route:       []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}},
+	}
+	shortRoute := &proxyconfig.RouteRule{
+		Destination: "reviews.default.svc.cluster.local",
+		Route:       []*proxyconfig.DestinationWeight{{Destination: "ratings"}},
+	}
+
+	// permissive mode allows short names
+	for _, rule := range []*proxyconfig.RouteRule{short, qualified, shortRoute} {
+		if err := ValidateRouteRule(rule); err != nil {
+			t.Errorf("ValidateRouteRule(%v) in permissive mode failed: %v", rule, err)
+		}
+	}
+
+	options := DefaultValidationOptions
+	options.RequireQualifiedDestinations = true
+	if err := options.ValidateRouteRule(qualified); err != nil {
+		t.Errorf("ValidateRouteRule(%v) failed: %v", qualified, err)
+	}
+	for _, rule := range []*proxyconfig.RouteRule{short, shortRoute} {
+		if err := options.ValidateRouteRule(rule); err == nil || !strings.Contains(err.Error(), "not fully qualified") {
+			t.Errorf("ValidateRouteRule(%v) => got %v, want error for short name", rule, err)
+		}
+	}
+}
+
+func TestNewIstioConfig(t *testing.T) {
+	options := DefaultValidationOptions
+	options.ProtectedServices = []string{"reviews"}
+	options.MaxTagValueLength = 2
+	options.MaxFaultDelaySeconds = 1
+	kinds := NewIstioConfig(options)
+
+	key := &Key{Kind: RouteRule, Name: "fault", Namespace: "default"}
+	fault := &proxyconfig.RouteRule{
+		Destination: "reviews.default.svc.cluster.local",
+		HttpFault: &proxyconfig.HTTPFaultInjection{
+			Delay: &proxyconfig.HTTPFaultInjection_Delay{
+				Percent:       10,
+				HttpDelayType: &proxyconfig.HTTPFaultInjection_Delay_FixedDelaySeconds{FixedDelaySeconds: 5},
+			},
+		},
+	}
+	if err := IstioConfig.ValidateConfig(key, fault); err != nil {
+		t.Errorf("ValidateConfig(%v) with the default options failed: %v", fault, err)
+	}
+	if err := kinds.ValidateConfig(key, fault); err == nil {
+		t.Errorf("ValidateConfig(%v) should reject a fault against a protected service", fault)
+	}
+	if got := IstioConfig.ConfigWarnings(key, fault); len(got) != 0 {
+		t.Errorf("ConfigWarnings(%v) with the default options => got %v, want none", fault, got)
+	}
+	if got := kinds.ConfigWarnings(key, fault); len(got) != 1 {
+		t.Errorf("ConfigWarnings(%v) => got %v, want a delay warning", fault, got)
+	}
+
+	key = &Key{Kind: DestinationPolicy, Name: "policy", Namespace: "default"}
+	policy := &proxyconfig.DestinationPolicy{
+		Destination: "ratings.default.svc.cluster.local",
+		Tags:        map[string]string{"version": "v10"},
+	}
+	if err := IstioConfig.ValidateConfig(key, policy); err != nil {
+		t.Errorf("ValidateConfig(%v) with the default options failed: %v", policy, err)
+	}
+	if err := kinds.ValidateConfig(key, policy); err == nil {
+		t.Errorf("ValidateConfig(%v) should reject a tag value above the maximum length", policy)
+	}
+}
+
+func TestSubnetOverlaps(t *testing.T) {
+	cases := []struct {
+		in   []string
+		want int
+	}{
+		{in: []string{"10.0.0.0/8", "192.168.0.0/16"}, want: 0},
+		{in: []string{"10.1.1.1", "10.1.1.2"}, want: 0},
+		{in: []string{"10.0.0.0/8", "10.1.0.0/16"}, want: 1},
+		{in: []string{"10.1.1.1", "10.1.0.0/16", "10.0.0.0/8"}, want: 2},
+		{in: []string{"10.1.0.0/16", "10.1.0.0/16"}, want: 1},
+		{in: []string{"2001:db8::/32", "2001:db8:1::/48"}, want: 1},
+		{in: []string{"2001:db8::1", "2001:db8::2"}, want: 0},
+		{in: []string{"10.0.0.0/8", "::/0"}, want: 0},
+	}
+	for _, c := range cases {
+		if got := subnetOverlaps(c.in); len(got) != c.want {
+			t.Errorf("subnetOverlaps(%v) => got %v, want %d overlaps", c.in, got, c.want)
+		}
+	}
+
+	shadowed := &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8", "10.1.0.0/16"}}
+	if err := ValidateL4MatchAttributes(shadowed); err != nil {
+		t.Errorf("ValidateL4MatchAttributes(%v) should only warn: %v", shadowed, err)
+	}
+}
+
+func TestValidateIPv6Subnet(t *testing.T) {
+	cases := []struct {
+		in    string
+		valid bool
+	}{
+		{in: "2001:db8::/32", valid: true},
+		{in: "2001:db8::1", valid: true},
+		{in: "2001:0db8:0000:0000:0000:ff00:0042:8329", valid: true},
+		{in: "::1", valid: true},
+		{in: "::/0", valid: true},
+		{in: "fe80::/128", valid: true},
+		{in: "::ffff:10.1.1.1", valid: true},
+		{in: "2001:db8::/129"},
+		{in: "2001:db8::/-1"},
+		{in: "2001:db8::/banana"},
+		{in: "2001:db8::/32/64"},
+		{in: "2001:db8:::1"},
+		{in: "2001:db8::g"},
+		{in: "1:2:3:4:5:6:7:8:9"},
+		{in: "fe80::1%eth0"},
+	}
+	for _, c := range cases {
+		if got := validateSubnet(c.in); (got == nil) != c.valid {
+			t.Errorf("validateSubnet(%q) => got valid=%v, want %v: %v", c.in, got == nil, c.valid, got)
+		}
+	}
+
+	match := &proxyconfig.L4MatchAttributes{
+		SourceSubnet:      []string{"2001:db8::/32", "10.1.0.0/16"},
+		DestinationSubnet: []string{"fd00::1"},
+	}
+	if err := ValidateL4MatchAttributes(match); err != nil {
+		t.Errorf("ValidateL4MatchAttributes(%v) => unexpected error %v", match, err)
+	}
+}
+
+func TestValidateSubsetTags(t *testing.T) {
+	if err := ValidateSubsetTags(Tags{"version": "v1.2", "env": "prod_us-east"}); err != nil {
+		t.Errorf("ValidateSubsetTags on clean tags failed: %v", err)
+	}
+	for _, tags := range []Tags{{"version": "release/v1"}, {"app/version": "v1"}} {
+		if err := tags.Validate(); err != nil {
+			t.Errorf("Tags.Validate(%v) should accept general tags: %v", tags, err)
+		}
+		if err := ValidateSubsetTags(tags); err == nil {
+			t.Errorf("ValidateSubsetTags(%v) should reject unsafe characters", tags)
+		}
+	}
+	rule := &proxyconfig.RouteRule{
+		Destination: "host.default.svc.cluster.local",
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "release/v1"}},
+		},
+	}
+	if err := ValidateRouteRule(rule); err == nil {
+		t.Errorf("ValidateRouteRule(%v) should reject unsafe subset tags", rule)
+	}
+}
+
 func TestTagsEquals(t *testing.T) {
 	cases := []struct {
 		a, b Tags
@@ -1057,6 +1477,659 @@ func TestValidateRouteAndIngressRule(t *testing.T) {
 	}
 }
 
+func TestValidateWeights(t *testing.T) {
+	negative := []*proxyconfig.DestinationWeight{
+		{Destination: "host2.default.svc.cluster.local", Weight: 150},
+		{Destination: "host3.default.svc.cluster.local", Weight: -50},
+	}
+	err := validateWeights(negative, "host.default.svc.cluster.local")
+	if err == nil || !strings.Contains(err.Error(), "host3.default.svc.cluster.local") {
+		t.Errorf("validateWeights(%v) => got %v, want error naming the negative destination", negative, err)
+	}
+
+	split := []*proxyconfig.DestinationWeight{
+		{Tags: map[string]string{"version": "v1"}, Weight: 75},
+		{Tags: map[string]string{"version": "v2"}, Weight: 25},
+	}
+	if err = validateWeights(split, "host.default.svc.cluster.local"); err != nil {
+		t.Errorf("validateWeights(%v) => got %v, want no error", split, err)
+	}
+	if got := unreachableRoutes(split, "host.default.svc.cluster.local"); len(got) != 0 {
+		t.Errorf("unreachableRoutes(%v) => got %v, want none", split, got)
+	}
+
+	single := []*proxyconfig.DestinationWeight{
+		{Tags: map[string]string{"version": "v1"}},
+	}
+	if got := unreachableRoutes(single, "host.default.svc.cluster.local"); len(got) != 0 {
+		t.Errorf("unreachableRoutes(%v) => got %v, want none", single, got)
+	}
+
+	zero := []*proxyconfig.DestinationWeight{
+		{Tags: map[string]string{"version": "v1"}, Weight: 100},
+		{Tags: map[string]string{"version": "v2"}, Weight: 0},
+	}
+	if err = validateWeights(zero, "host.default.svc.cluster.local"); err != nil {
+		t.Errorf("validateWeights(%v) should only warn: %v", zero, err)
+	}
+	got := unreachableRoutes(zero, "host.default.svc.cluster.local")
+	if len(got) != 1 || !strings.Contains(got[0], "version=v2") {
+		t.Errorf("unreachableRoutes(%v) => got %v, want the v2 destination", zero, got)
+	}
+}
+
+func TestValidateHTTPFaultZeroPercent(t *testing.T) {
+	for _, percent := range []float32{0, 50} {
+		fault := &proxyconfig.HTTPFaultInjection{
+			Delay: &proxyconfig.HTTPFaultInjection_Delay{
+				Percent:       percent,
+				HttpDelayType: &proxyconfig.HTTPFaultInjection_Delay_FixedDelaySeconds{FixedDelaySeconds: 5},
+			},
+			Abort: &proxyconfig.HTTPFaultInjection_Abort{
+				Percent:   percent,
+				ErrorType: &proxyconfig.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: 500},
+			},
+		}
+		if err := ValidateHTTPFault(fault); err != nil {
+			t.Errorf("ValidateHTTPFault(%v%%) should only warn: %v", percent, err)
+		}
+	}
+}
+
+func TestIsEmptyMatch(t *testing.T) {
+	cases := []struct {
+		name  string
+		match *proxyconfig.MatchCondition
+		empty bool
+	}{
+		{name: "empty", match: &proxyconfig.MatchCondition{}, empty: true},
+		{name: "empty tcp", match: &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{}}, empty: true},
+		{name: "source", match: &proxyconfig.MatchCondition{Source: "reviews.default.svc.cluster.local"}},
+		{name: "source tags", match: &proxyconfig.MatchCondition{SourceTags: map[string]string{"version": "v1"}}},
+		{
+			name: "headers",
+			match: &proxyconfig.MatchCondition{HttpHeaders: map[string]*proxyconfig.StringMatch{
+				"cookie": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "user=jason"}},
+			}},
+		},
+		{
+			name:  "subnet",
+			match: &proxyconfig.MatchCondition{Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8"}}},
+		},
+	}
+	for _, c := range cases {
+		if got := isEmptyMatch(c.match); got != c.empty {
+			t.Errorf("%s: isEmptyMatch => got %v, want %v", c.name, got, c.empty)
+		}
+		if err := ValidateMatchCondition(c.match); err != nil {
+			t.Errorf("%s: ValidateMatchCondition should only warn: %v", c.name, err)
+		}
+	}
+}
+
+func TestValidateMatchConditionHeaders(t *testing.T) {
+	cases := []struct {
+		name    string
+		headers map[string]*proxyconfig.StringMatch
+		valid   bool
+	}{
+		{
+			name: "valid regex",
+			headers: map[string]*proxyconfig.StringMatch{
+				"cookie": {MatchType: &proxyconfig.StringMatch_Regex{Regex: "^(.*?;)?(user=jason)(;.*)?$"}},
+			},
+			valid: true,
+		},
+		{
+			name: "exact is not a regex",
+			headers: map[string]*proxyconfig.StringMatch{
+				"cookie": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "[unclosed"}},
+			},
+			valid: true,
+		},
+		{
+			name: "broken regex",
+			headers: map[string]*proxyconfig.StringMatch{
+				"cookie": {MatchType: &proxyconfig.StringMatch_Regex{Regex: "[unclosed"}},
+			},
+		},
+		{
+			name: "invalid name",
+			headers: map[string]*proxyconfig.StringMatch{
+				"bad header": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "x"}},
+			},
+		},
+		{
+			name: "empty name",
+			headers: map[string]*proxyconfig.StringMatch{
+				"": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "x"}},
+			},
+		},
+	}
+	for _, c := range cases {
+		err := ValidateMatchCondition(&proxyconfig.MatchCondition{HttpHeaders: c.headers})
+		if (err == nil) != c.valid {
+			t.Errorf("%s: ValidateMatchCondition => got valid=%v, want %v: %v", c.name, err == nil, c.valid, err)
+		}
+	}
+}
+
+func TestExceedsMaxDelay(t *testing.T) {
+	huge := &proxyconfig.HTTPFaultInjection_Delay{
+		Percent:       10,
+		HttpDelayType: &proxyconfig.HTTPFaultInjection_Delay_FixedDelaySeconds{FixedDelaySeconds: 3600},
+	}
+	if !exceedsMaxDelay(huge, DefaultValidationOptions.MaxFaultDelaySeconds) {
+		t.Errorf("exceedsMaxDelay(%v) => got false, want true", huge)
+	}
+	if err := validateDelay(huge); err != nil {
+		t.Errorf("validateDelay(%v) should only warn: %v", huge, err)
+	}
+
+	normal := &proxyconfig.HTTPFaultInjection_Delay{
+		Percent:       10,
+		HttpDelayType: &proxyconfig.HTTPFaultInjection_Delay_FixedDelaySeconds{FixedDelaySeconds: 5},
+	}
+	if exceedsMaxDelay(normal, DefaultValidationOptions.MaxFaultDelaySeconds) {
+		t.Errorf("exceedsMaxDelay(%v) => got true, want false", normal)
+	}
+	if !exceedsMaxDelay(normal, 1) {
+		t.Errorf("exceedsMaxDelay(%v, 1) => got false, want true", normal)
+	}
+}
+
+func TestHasRouteBehavior(t *testing.T) {
+	empty := &proxyconfig.RouteRule{Destination: "host.default.svc.cluster.local"}
+	if hasRouteBehavior(empty) {
+		t.Errorf("hasRouteBehavior(%v) => got true, want false", empty)
+	}
+	if err := ValidateRouteRule(empty); err != nil {
+		t.Errorf("ValidateRouteRule(%v) should only warn: %v", empty, err)
+	}
+
+	minimal := &proxyconfig.RouteRule{
+		Destination:    "host.default.svc.cluster.local",
+		HttpReqTimeout: &proxyconfig.HTTPTimeout{},
+	}
+	if !hasRouteBehavior(minimal) {
+		t.Errorf("hasRouteBehavior(%v) => got false, want true", minimal)
+	}
+}
+
+func TestValidateProtectedFault(t *testing.T) {
+	fault := &proxyconfig.HTTPFaultInjection{
+		Abort: &proxyconfig.HTTPFaultInjection_Abort{
+			Percent:   50,
+			ErrorType: &proxyconfig.HTTPFaultInjection_Abort_HttpStatus{HttpStatus: 500},
+		},
+	}
+	cases := []struct {
+		name  string
+		in    *proxyconfig.RouteRule
+		valid bool
+	}{
+		{name: "fault against normal service", in: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			HttpFault:   fault,
+		},
+			valid: true},
+		{name: "fault against protected service", in: &proxyconfig.RouteRule{
+			Destination: "istio-mixer.default.svc.cluster.local",
+			HttpFault:   fault,
+		},
+			valid: false},
+		{name: "fault against protected short name", in: &proxyconfig.RouteRule{
+			Destination: "istio-manager",
+			HttpFault:   fault,
+		},
+			valid: false},
+		{name: "fault against service with protected prefix", in: &proxyconfig.RouteRule{
+			Destination: "istio-mixer-test.default.svc.cluster.local",
+			HttpFault:   fault,
+		},
+			valid: true},
+		{name: "protected service without fault", in: &proxyconfig.RouteRule{
+			Destination: "istio-mixer.default.svc.cluster.local",
+		},
+			valid: true},
+	}
+	for _, c := range cases {
+		if got := ValidateRouteRule(c.in); (got == nil) != c.valid {
+			t.Errorf("ValidateRouteRule failed on %v: got valid=%v but wanted valid=%v: %v", c.name, got == nil, c.valid, got)
+		}
+	}
+
+	if err := ValidateProtectedFault(cases[0].in, []string{"host.default.svc.cluster.local"}); err == nil {
+		t.Error("ValidateProtectedFault should reject a fault against a custom protected service")
+	}
+}
+
+func makeIngressRule(destination, host string, uri *proxyconfig.StringMatch) *proxyconfig.RouteRule {
+	headers := map[string]*proxyconfig.StringMatch{"uri": uri}
+	if host != "" {
+		headers["authority"] = &proxyconfig.StringMatch{
+			MatchType: &proxyconfig.StringMatch_Exact{Exact: host},
+		}
+	}
+	return &proxyconfig.RouteRule{
+		Destination: destination,
+		Match:       &proxyconfig.MatchCondition{HttpHeaders: headers},
+	}
+}
+
+func TestValidateIngressRuleConflicts(t *testing.T) {
+	exact := func(path string) *proxyconfig.StringMatch {
+		return &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Exact{Exact: path}}
+	}
+	prefix := func(path string) *proxyconfig.StringMatch {
+		return &proxyconfig.StringMatch{MatchType: &proxyconfig.StringMatch_Prefix{Prefix: path}}
+	}
+	key := func(name string) Key {
+		return Key{Kind: IngressRule, Name: name, Namespace: "default"}
+	}
+	cases := []struct {
+		name     string
+		in       map[Key]*proxyconfig.RouteRule
+		valid    bool
+		overlaps int
+	}{
+		{name: "exact collision", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): makeIngressRule("a.default.svc.cluster.local", "", exact("/api")),
+			key("b"): makeIngressRule("b.default.svc.cluster.local", "", exact("/api")),
+		},
+			valid: false},
+		{name: "same destination", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): makeIngressRule("a.default.svc.cluster.local", "", exact("/api")),
+			key("b"): makeIngressRule("a.default.svc.cluster.local", "", exact("/api")),
+		},
+			valid: true},
+		{name: "different hosts", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): makeIngressRule("a.default.svc.cluster.local", "foo.com", prefix("/api")),
+			key("b"): makeIngressRule("b.default.svc.cluster.local", "bar.com", prefix("/api")),
+		},
+			valid: true},
+		{name: "exact and prefix", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): makeIngressRule("a.default.svc.cluster.local", "", exact("/api")),
+			key("b"): makeIngressRule("b.default.svc.cluster.local", "", prefix("/api")),
+		},
+			valid: true, overlaps: 0},
+		{name: "prefix overlap", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): makeIngressRule("a.default.svc.cluster.local", "", prefix("/api")),
+			key("b"): makeIngressRule("b.default.svc.cluster.local", "", prefix("/api/v1")),
+		},
+			valid: true, overlaps: 1},
+	}
+	for _, c := range cases {
+		if got := ValidateIngressRuleConflicts(c.in); (got == nil) != c.valid {
+			t.Errorf("ValidateIngressRuleConflicts failed on %v: got valid=%v but wanted valid=%v: %v",
+				c.name, got == nil, c.valid, got)
+		} else if got != nil && !strings.Contains(got.Error(), key("a").String()) {
+			t.Errorf("ValidateIngressRuleConflicts on %v should report the rule keys: %v", c.name, got)
+		}
+		if got := ingressRuleOverlaps(c.in); len(got) != c.overlaps {
+			t.Errorf("ingressRuleOverlaps on %v got %v, want %d overlaps", c.name, got, c.overlaps)
+		}
+	}
+}
+
+func TestValidateIngressRouteCollisions(t *testing.T) {
+	discovery := fakeDiscovery{service: &Service{
+		Hostname: "hello.default.svc.cluster.local",
+		Ports:    PortList{{Name: "http", Port: 80, Protocol: ProtocolHTTP}},
+	}}
+	ingressKey := Key{Kind: IngressRule, Name: "ingress", Namespace: "default"}
+	routeKey := Key{Kind: RouteRule, Name: "route", Namespace: "default"}
+	routes := map[Key]*proxyconfig.RouteRule{
+		routeKey: {Destination: "hello.default.svc.cluster.local"},
+	}
+
+	colliding := map[Key]*proxyconfig.RouteRule{
+		ingressKey: makeIngressRule("world.default.svc.cluster.local", "hello.default.svc.cluster.local", nil),
+	}
+	err := ValidateIngressRouteCollisions(colliding, routes, 80, discovery)
+	if err == nil || !strings.Contains(err.Error(), ingressKey.String()) ||
+		!strings.Contains(err.Error(), routeKey.String()) {
+		t.Errorf("ValidateIngressRouteCollisions(%v) => got %v, want error naming both rules", colliding, err)
+	}
+	if err = ValidateIngressRouteCollisions(colliding, routes, 443, discovery); err != nil {
+		t.Errorf("ValidateIngressRouteCollisions(%v) on another port => unexpected error %v", colliding, err)
+	}
+
+	distinct := map[Key]*proxyconfig.RouteRule{
+		ingressKey: makeIngressRule("world.default.svc.cluster.local", "foo.com", nil),
+	}
+	if err = ValidateIngressRouteCollisions(distinct, routes, 80, discovery); err != nil {
+		t.Errorf("ValidateIngressRouteCollisions(%v) => unexpected error %v", distinct, err)
+	}
+}
+
+func TestIstioRegistryCheck(t *testing.T) {
+	discovery := fakeDiscovery{service: &Service{
+		Hostname: "hello.default.svc.cluster.local",
+		Ports:    PortList{{Name: "http", Port: 80, Protocol: ProtocolHTTP}},
+	}}
+	dangling := Key{Kind: RouteRule, Name: "dangling", Namespace: "default"}
+	invalid := Key{Kind: RouteRule, Name: "invalid", Namespace: "default"}
+	orphan := Key{Kind: DestinationPolicy, Name: "orphan", Namespace: "default"}
+	configs := map[string]map[Key]proto.Message{
+		RouteRule: {
+			dangling: &proxyconfig.RouteRule{Destination: "world.default.svc.cluster.local"},
+			invalid: &proxyconfig.RouteRule{
+				Destination: "hello.default.svc.cluster.local",
+				Route:       []*proxyconfig.DestinationWeight{{Weight: 50}, {Weight: 20}},
+			},
+		},
+		DestinationPolicy: {
+			orphan: &proxyconfig.DestinationPolicy{
+				Destination: "hello.default.svc.cluster.local",
+				Tags:        map[string]string{"version": "v2"},
+			},
+		},
+	}
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mock := NewMockConfigRegistry(ctrl)
+	for _, kind := range IstioConfig.Kinds() {
+		mock.EXPECT().List(kind, "").Return(configs[kind], nil).AnyTimes()
+	}
+	report := (&IstioRegistry{ConfigRegistry: mock}).Check(IstioConfig, discovery)
+
+	cases := []struct {
+		key   string
+		valid bool
+		want  string
+	}{
+		{key: "service/hello.default.svc.cluster.local", valid: true},
+		{key: "instance/hello.default.svc.cluster.local/10.1.1.1:80", valid: true},
+		{key: dangling.String(), valid: false, want: "world.default.svc.cluster.local"},
+		{key: invalid.String(), valid: false, want: "total 70"},
+		{key: orphan.String(), valid: true, want: "version=v2"},
+		{key: RegistryCheckKey, valid: true},
+	}
+	if len(report) != len(cases) {
+		t.Errorf("Check() => got %d results, want %d: %v", len(report), len(cases), spew.Sdump(report))
+	}
+	for _, c := range cases {
+		result, ok := report[c.key]
+		if !ok {
+			t.Errorf("Check() => missing result for %s", c.key)
+			continue
+		}
+		if result.Valid != c.valid {
+			t.Errorf("Check() => %s got valid=%v, want %v: %v", c.key, result.Valid, c.valid, result.Errors)
+		}
+		if c.want != "" && !strings.Contains(strings.Join(append(result.Errors, result.Warnings...), "\n"), c.want) {
+			t.Errorf("Check() => %s got %v, want a finding with %q", c.key, result, c.want)
+		}
+	}
+}
+
+func TestValidateRouteRuleCycles(t *testing.T) {
+	key := func(name string) Key {
+		return Key{Kind: RouteRule, Name: name, Namespace: "default"}
+	}
+	rule := func(from string, to ...string) *proxyconfig.RouteRule {
+		out := &proxyconfig.RouteRule{Destination: from}
+		for _, destination := range to {
+			out.Route = append(out.Route, &proxyconfig.DestinationWeight{Destination: destination})
+		}
+		return out
+	}
+	cases := []struct {
+		name  string
+		in    map[Key]*proxyconfig.RouteRule
+		cycle string
+	}{
+		{name: "two rules", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): rule("a.default.svc.cluster.local", "b.default.svc.cluster.local"),
+			key("b"): rule("b.default.svc.cluster.local", "a.default.svc.cluster.local"),
+		}, cycle: "a.default.svc.cluster.local -> b.default.svc.cluster.local -> a.default.svc.cluster.local"},
+		{name: "three rules", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): rule("a.default.svc.cluster.local", "b.default.svc.cluster.local"),
+			key("b"): rule("b.default.svc.cluster.local", "c.default.svc.cluster.local"),
+			key("c"): rule("c.default.svc.cluster.local", "a.default.svc.cluster.local"),
+		}, cycle: "a.default.svc.cluster.local -> b.default.svc.cluster.local -> " +
+			"c.default.svc.cluster.local -> a.default.svc.cluster.local"},
+		{name: "acyclic chain", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): rule("a.default.svc.cluster.local", "b.default.svc.cluster.local", "c.default.svc.cluster.local"),
+			key("b"): rule("b.default.svc.cluster.local", "c.default.svc.cluster.local"),
+			key("c"): rule("c.default.svc.cluster.local", "c.default.svc.cluster.local"),
+		}},
+		{name: "same destination", in: map[Key]*proxyconfig.RouteRule{
+			key("a"): rule("a.default.svc.cluster.local", ""),
+		}},
+	}
+	for _, c := range cases {
+		err := ValidateRouteRuleCycles(c.in)
+		if c.cycle == "" {
+			if err != nil {
+				t.Errorf("ValidateRouteRuleCycles on %s => unexpected error %v", c.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), c.cycle) || !strings.Contains(err.Error(), key("b").String()) {
+			t.Errorf("ValidateRouteRuleCycles on %s => got %v, want loop %s", c.name, err, c.cycle)
+		}
+	}
+}
+
+func TestValidateProxyMeshConfig(t *testing.T) {
+	valid := func() *proxyconfig.ProxyMeshConfig {
+		return &proxyconfig.ProxyMeshConfig{
+			DiscoveryAddress:       "manager:8080",
+			MixerAddress:           "mixer:9091",
+			ProxyListenPort:        15001,
+			ProxyAdminPort:         15000,
+			DrainDuration:          &duration.Duration{Seconds: 2},
+			ParentShutdownDuration: &duration.Duration{Seconds: 3},
+			DiscoveryRefreshDelay:  &duration.Duration{Seconds: 1},
+			ConnectTimeout:         &duration.Duration{Seconds: 1},
+			IstioServiceCluster:    "istio-proxy",
+		}
+	}
+	if err := ValidateProxyMeshConfig(valid()); err != nil {
+		t.Errorf("ValidateProxyMeshConfig(valid) => unexpected error %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		modify func(*proxyconfig.ProxyMeshConfig)
+	}{
+		{"missing discovery address", func(m *proxyconfig.ProxyMeshConfig) { m.DiscoveryAddress = "" }},
+		{"discovery address without port", func(m *proxyconfig.ProxyMeshConfig) { m.DiscoveryAddress = "manager" }},
+		{"mixer address port", func(m *proxyconfig.ProxyMeshConfig) { m.MixerAddress = "mixer:http" }},
+		{"egress address without host", func(m *proxyconfig.ProxyMeshConfig) { m.EgressProxyAddress = ":80" }},
+		{"listen port", func(m *proxyconfig.ProxyMeshConfig) { m.ProxyListenPort = 0 }},
+		{"admin port", func(m *proxyconfig.ProxyMeshConfig) { m.ProxyAdminPort = 70000 }},
+		{"drain duration", func(m *proxyconfig.ProxyMeshConfig) { m.DrainDuration = &duration.Duration{Seconds: -1} }},
+		{"parent shutdown duration", func(m *proxyconfig.ProxyMeshConfig) {
+			m.ParentShutdownDuration = &duration.Duration{Seconds: -1}
+		}},
+		{"discovery refresh delay", func(m *proxyconfig.ProxyMeshConfig) {
+			m.DiscoveryRefreshDelay = &duration.Duration{Nanos: -1}
+		}},
+		{"connect timeout", func(m *proxyconfig.ProxyMeshConfig) {
+			m.ConnectTimeout = &duration.Duration{Seconds: 1, Nanos: -1}
+		}},
+		{"service cluster", func(m *proxyconfig.ProxyMeshConfig) { m.IstioServiceCluster = "Istio_Proxy" }},
+		{"empty service cluster", func(m *proxyconfig.ProxyMeshConfig) { m.IstioServiceCluster = "" }},
+	}
+	for _, c := range cases {
+		mesh := valid()
+		c.modify(mesh)
+		if err := ValidateProxyMeshConfig(mesh); err == nil {
+			t.Errorf("ValidateProxyMeshConfig(%s) => expected error", c.name)
+		}
+	}
+}
+
+func TestValidateProxyAdminPort(t *testing.T) {
+	instances := []*ServiceInstance{serviceInstance1, serviceInstance2}
+	cases := []struct {
+		name   string
+		admin  int32
+		listen int32
+		valid  bool
+	}{
+		{name: "default", admin: 15000, listen: 15001, valid: true},
+		{name: "zero", admin: 0, listen: 15001},
+		{name: "out of range", admin: 70000, listen: 15001},
+		{name: "listen port", admin: 15001, listen: 15001},
+		{name: "endpoint port", admin: int32(endpoint1.Port), listen: 15001},
+	}
+	for _, c := range cases {
+		mesh := &proxyconfig.ProxyMeshConfig{ProxyAdminPort: c.admin, ProxyListenPort: c.listen}
+		if got := ValidateProxyAdminPort(mesh, instances); (got == nil) != c.valid {
+			t.Errorf("ValidateProxyAdminPort on %s => got valid=%v, want %v: %v", c.name, got == nil, c.valid, got)
+		}
+	}
+}
+
+// fakeDiscovery exposes a single service with instances tagged version=v1
+type fakeDiscovery struct {
+	service *Service
+}
+
+func (d fakeDiscovery) Services() []*Service {
+	return []*Service{d.service}
+}
+func (d fakeDiscovery) GetService(hostname string) (*Service, bool) {
+	return d.service, hostname == d.service.Hostname
+}
+func (d fakeDiscovery) Instances(hostname string, ports []string, tags TagsList) []*ServiceInstance {
+	instance := &ServiceInstance{
+		Endpoint: NetworkEndpoint{Address: "10.1.1.1", Port: 80, ServicePort: d.service.Ports[0]},
+		Service:  d.service,
+		Tags:     Tags{"version": "v1"},
+	}
+	if hostname != d.service.Hostname || !tags.HasSubsetOf(instance.Tags) {
+		return nil
+	}
+	return []*ServiceInstance{instance}
+}
+func (d fakeDiscovery) HostInstances(addrs map[string]bool) []*ServiceInstance {
+	return nil
+}
+func (d fakeDiscovery) GetIstioServiceAccounts(hostname string, ports []string) []string {
+	return nil
+}
+
+func TestCheckDestinationPolicyEndpoints(t *testing.T) {
+	discovery := fakeDiscovery{service: &Service{
+		Hostname: "hello.default.svc.cluster.local",
+		Ports:    PortList{{Name: "http", Port: 80, Protocol: ProtocolHTTP}},
+	}}
+	cases := []struct {
+		in   *proxyconfig.DestinationPolicy
+		want bool
+	}{
+		{in: &proxyconfig.DestinationPolicy{
+			Destination: "hello.default.svc.cluster.local",
+			Tags:        map[string]string{"version": "v1"},
+		}, want: true},
+		{in: &proxyconfig.DestinationPolicy{
+			Destination: "hello.default.svc.cluster.local",
+			Tags:        map[string]string{"version": "v2"},
+		}, want: false},
+		{in: &proxyconfig.DestinationPolicy{
+			Destination: "world.default.svc.cluster.local",
+			Tags:        map[string]string{"version": "v1"},
+		}, want: false},
+		{in: &proxyconfig.DestinationPolicy{
+			Destination: "world.default.svc.cluster.local",
+		}, want: true},
+	}
+	for _, c := range cases {
+		if got := CheckDestinationPolicyEndpoints(c.in, discovery); got != c.want {
+			t.Errorf("CheckDestinationPolicyEndpoints(%v) => got %v, want %v", c.in, got, c.want)
+		}
+	}
+}
+
+func TestCheckServiceInstances(t *testing.T) {
+	ports := PortList{{Name: "http", Port: 80, Protocol: ProtocolHTTP}}
+	populated := &Service{Hostname: "hello.default.svc.cluster.local", Ports: ports}
+	discovery := fakeDiscovery{service: populated}
+	if !CheckServiceInstances(populated, discovery) {
+		t.Errorf("CheckServiceInstances(%s) => got false, want true", populated.Hostname)
+	}
+	empty := &Service{Hostname: "world.default.svc.cluster.local", Ports: ports}
+	if CheckServiceInstances(empty, discovery) {
+		t.Errorf("CheckServiceInstances(%s) => got true, want false", empty.Hostname)
+	}
+}
+
+func TestValidationReport(t *testing.T) {
+	configs := map[Key]proto.Message{
+		{Kind: RouteRule, Name: "valid", Namespace: "default"}: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Precedence:  1,
+			Route:       []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}},
+		},
+		{Kind: RouteRule, Name: "noop", Namespace: "default"}: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+		},
+		{Kind: RouteRule, Name: "invalid", Namespace: "default"}: &proxyconfig.RouteRule{},
+		{Kind: RouteRule, Name: "fault", Namespace: "default"}: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Route: []*proxyconfig.DestinationWeight{
+				{Tags: map[string]string{"version": "v1"}, Weight: 100},
+				{Tags: map[string]string{"version": "v2"}},
+			},
+			HttpFault: &proxyconfig.HTTPFaultInjection{
+				Delay: &proxyconfig.HTTPFaultInjection_Delay{
+					HttpDelayType: &proxyconfig.HTTPFaultInjection_Delay_FixedDelaySeconds{FixedDelaySeconds: 3600},
+				},
+			},
+		},
+		{Kind: IngressRule, Name: "subnets", Namespace: "default"}: &proxyconfig.RouteRule{
+			Destination: "host.default.svc.cluster.local",
+			Match: &proxyconfig.MatchCondition{
+				Tcp: &proxyconfig.L4MatchAttributes{SourceSubnet: []string{"10.0.0.0/8", "10.1.0.0/16"}},
+			},
+		},
+		{Kind: DestinationPolicy, Name: "breaker", Namespace: "default"}: &proxyconfig.DestinationPolicy{
+			Destination: "host.default.svc.cluster.local",
+			CircuitBreaker: &proxyconfig.CircuitBreaker{
+				CbPolicy: &proxyconfig.CircuitBreaker_SimpleCb{
+					SimpleCb: &proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy{HttpMaxRequestsPerConnection: 1},
+				},
+			},
+		},
+	}
+	out, err := IstioConfig.ValidationReport(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report map[string]ValidationResult
+	if err = json.Unmarshal(out, &report); err != nil {
+		t.Fatalf("invalid JSON report %s: %v", out, err)
+	}
+	if len(report) != len(configs) {
+		t.Errorf("ValidationReport => got %d entries, want %d: %s", len(report), len(configs), out)
+	}
+	if got := report["default/route-rule-valid"]; !got.Valid || len(got.Errors) != 0 || len(got.Warnings) != 0 {
+		t.Errorf("ValidationReport valid rule => got %+v", got)
+	}
+	if got := report["default/route-rule-noop"]; !got.Valid || len(got.Warnings) != 1 {
+		t.Errorf("ValidationReport no-op rule => got %+v, want one warning", got)
+	}
+	if got := report["default/route-rule-invalid"]; got.Valid || len(got.Errors) == 0 {
+		t.Errorf("ValidationReport invalid rule => got %+v, want errors", got)
+	}
+	// delay percent 0, delay above the maximum, and an unreachable route
+	if got := report["default/route-rule-fault"]; !got.Valid || len(got.Warnings) != 3 {
+		t.Errorf("ValidationReport fault rule => got %+v, want three warnings", got)
+	}
+	if got := report["default/ingress-rule-subnets"]; !got.Valid || len(got.Warnings) != 1 {
+		t.Errorf("ValidationReport overlapping subnets => got %+v, want one warning", got)
+	}
+	if got := report["default/destination-policy-breaker"]; !got.Valid || len(got.Warnings) != 1 {
+		t.Errorf("ValidationReport circuit breaker => got %+v, want one warning", got)
+	}
+}
+
 func TestValidateDestinationPolicy(t *testing.T) {
 	cases := []struct {
 		in    proto.Message
@@ -1115,3 +2188,151 @@ func TestValidateDestinationPolicy(t *testing.T) {
 		}
 	}
 }
+
+func TestRouteRuleShadows(t *testing.T) {
+	key := func(name string) Key {
+		return Key{Kind: RouteRule, Name: name, Namespace: "default"}
+	}
+	catchAll := func(precedence int32) *proxyconfig.RouteRule {
+		return &proxyconfig.RouteRule{
+			Destination: "reviews.default.svc.cluster.local",
+			Precedence:  precedence,
+			Route:       []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}},
+		}
+	}
+	specific := func(precedence int32) *proxyconfig.RouteRule {
+		return &proxyconfig.RouteRule{
+			Destination: "reviews.default.svc.cluster.local",
+			Precedence:  precedence,
+			Match: &proxyconfig.MatchCondition{
+				HttpHeaders: map[string]*proxyconfig.StringMatch{
+					"cookie": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "user=jason"}},
+				},
+			},
+			Route: []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v2"}}},
+		}
+	}
+
+	shadowing := map[Key]*proxyconfig.RouteRule{key("catch-all"): catchAll(2), key("specific"): specific(1)}
+	if got := RouteRuleShadows(shadowing); len(got) != 1 {
+		t.Errorf("RouteRuleShadows(shadowing) => got %v, want one shadow", got)
+	} else if !strings.Contains(got[0], "catch-all") || !strings.Contains(got[0], "specific") {
+		t.Errorf("RouteRuleShadows(shadowing) should name both rules: %v", got[0])
+	}
+
+	ordered := map[Key]*proxyconfig.RouteRule{key("catch-all"): catchAll(1), key("specific"): specific(2)}
+	if got := RouteRuleShadows(ordered); len(got) != 0 {
+		t.Errorf("RouteRuleShadows(ordered) => got %v, want none", got)
+	}
+}
+
+func TestStackedFaultRules(t *testing.T) {
+	key := func(name string) Key {
+		return Key{Kind: RouteRule, Name: name, Namespace: "default"}
+	}
+	faulty := func(precedence int32, match *proxyconfig.MatchCondition) *proxyconfig.RouteRule {
+		return &proxyconfig.RouteRule{
+			Destination: "reviews.default.svc.cluster.local",
+			Precedence:  precedence,
+			Match:       match,
+			HttpFault: &proxyconfig.HTTPFaultInjection{
+				Delay: &proxyconfig.HTTPFaultInjection_Delay{
+					Percent:       50,
+					HttpDelayType: &proxyconfig.HTTPFaultInjection_Delay_FixedDelaySeconds{FixedDelaySeconds: 2},
+				},
+			},
+		}
+	}
+	jason := &proxyconfig.MatchCondition{
+		HttpHeaders: map[string]*proxyconfig.StringMatch{
+			"cookie": {MatchType: &proxyconfig.StringMatch_Exact{Exact: "user=jason"}},
+		},
+	}
+	routeOnly := &proxyconfig.RouteRule{
+		Destination: "reviews.default.svc.cluster.local",
+		Route:       []*proxyconfig.DestinationWeight{{Tags: map[string]string{"version": "v1"}}},
+	}
+
+	stacked := map[Key]*proxyconfig.RouteRule{key("all"): faulty(1, nil), key("jason"): faulty(2, jason)}
+	if got := StackedFaultRules(stacked); len(got) != 1 {
+		t.Errorf("StackedFaultRules(stacked) => got %v, want one warning", got)
+	} else if !strings.Contains(got[0], "all") || !strings.Contains(got[0], "jason") {
+		t.Errorf("StackedFaultRules(stacked) should name both rules: %v", got[0])
+	}
+
+	single := map[Key]*proxyconfig.RouteRule{key("all"): faulty(1, nil), key("route"): routeOnly}
+	if got := StackedFaultRules(single); len(got) != 0 {
+		t.Errorf("StackedFaultRules(single) => got %v, want none", got)
+	}
+
+	other := faulty(2, nil)
+	other.Destination = "ratings.default.svc.cluster.local"
+	distinct := map[Key]*proxyconfig.RouteRule{key("all"): faulty(1, nil), key("other"): other}
+	if got := StackedFaultRules(distinct); len(got) != 0 {
+		t.Errorf("StackedFaultRules(distinct) => got %v, want none", got)
+	}
+}
+
+func TestDestinationPolicyWildcardWarnings(t *testing.T) {
+	wildcard := &proxyconfig.DestinationPolicy{Destination: "*.default.svc.cluster.local"}
+	if got := DestinationPolicyWarnings(wildcard); len(got) != 1 || !strings.Contains(got[0], "wildcard") {
+		t.Errorf("DestinationPolicyWarnings(wildcard) => got %v, want a wildcard warning", got)
+	}
+
+	specific := &proxyconfig.DestinationPolicy{Destination: "reviews.default.svc.cluster.local"}
+	if err := ValidateDestinationPolicy(specific); err != nil {
+		t.Errorf("ValidateDestinationPolicy(specific) => unexpected error %v", err)
+	}
+	if got := DestinationPolicyWarnings(specific); len(got) != 0 {
+		t.Errorf("DestinationPolicyWarnings(specific) => got %v, want none", got)
+	}
+}
+
+func TestThrottleFootguns(t *testing.T) {
+	cases := []struct {
+		name     string
+		throttle *proxyconfig.L4FaultInjection_Throttle
+		footguns int
+	}{
+		{
+			name: "sane",
+			throttle: &proxyconfig.L4FaultInjection_Throttle{
+				Percent:            50,
+				DownstreamLimitBps: 1000,
+				UpstreamLimitBps:   1000,
+				ThrottleAfter:      &proxyconfig.L4FaultInjection_Throttle_ThrottleAfterBytes{ThrottleAfterBytes: 1024},
+			},
+		},
+		{
+			name:     "no limits",
+			throttle: &proxyconfig.L4FaultInjection_Throttle{Percent: 50},
+			footguns: 1,
+		},
+		{
+			name: "upstream below downstream",
+			throttle: &proxyconfig.L4FaultInjection_Throttle{
+				Percent:            50,
+				DownstreamLimitBps: 1000,
+				UpstreamLimitBps:   10,
+			},
+			footguns: 1,
+		},
+		{
+			name: "unrealistic throttle after bytes",
+			throttle: &proxyconfig.L4FaultInjection_Throttle{
+				Percent:            50,
+				DownstreamLimitBps: 1000,
+				ThrottleAfter:      &proxyconfig.L4FaultInjection_Throttle_ThrottleAfterBytes{ThrottleAfterBytes: 1e15},
+			},
+			footguns: 1,
+		},
+	}
+	for _, c := range cases {
+		if got := throttleFootguns(c.throttle); len(got) != c.footguns {
+			t.Errorf("%s: got footguns %v, want %d", c.name, got, c.footguns)
+		}
+		if err := validateThrottle(c.throttle); err != nil {
+			t.Errorf("%s: validateThrottle should only warn: %v", c.name, err)
+		}
+	}
+}
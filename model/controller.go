@@ -43,6 +43,10 @@ type Controller interface {
 
 	// Run until a signal is received
 	Run(stop <-chan struct{})
+
+	// Ready returns a channel that is closed once the controller has
+	// populated the initial registry state after Run is called
+	Ready() <-chan struct{}
 }
 
 // Event represents a registry update event
@@ -0,0 +1,40 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build gofuzz
+// +build gofuzz
+
+package model
+
+// Fuzz is the go-fuzz entry point for ParseServiceKey, which parses service
+// keys from SDS request paths:
+//
+//	go-fuzz-build istio.io/manager/model
+//	go-fuzz -bin=model-fuzz.zip -workdir=fuzz
+func Fuzz(data []byte) int {
+	hostname, ports, tags := ParseServiceKey(string(data))
+	if len(ports) == 0 {
+		panic("ParseServiceKey returned no ports")
+	}
+	// tags with an empty key must not select anything
+	for _, tag := range tags {
+		if _, ok := tag[""]; ok && (TagsList{tag}).HasSubsetOf(tag) {
+			panic("ParseServiceKey returned a satisfiable tag without a key")
+		}
+	}
+	if hostname == "" {
+		return 0
+	}
+	return 1
+}
@@ -49,6 +49,11 @@ type Service struct {
 	// Ports is the set of network ports where the service is listening for
 	// connections
 	Ports PortList `json:"ports,omitempty"`
+
+	// ExternalName is the DNS name of a service outside of the mesh, e.g.
+	// "api.example.com". External services have no instances in the mesh and
+	// the proxies resolve the name by DNS.
+	ExternalName string `json:"external,omitempty"`
 }
 
 // Port represents a network port where a service is listening for
@@ -114,6 +119,10 @@ type NetworkEndpoint struct {
 	// the service associated with this instance (e.g.,
 	// catalog.mystore.com)
 	ServicePort *Port `json:"service_port"`
+
+	// SNI is the optional TLS server name presented when originating TLS to
+	// this endpoint, for backends that serve several names on one address
+	SNI string `json:"sni,omitempty"`
 }
 
 // Tags is a non empty set of arbitrary strings. Each version of a service can
@@ -215,6 +224,11 @@ func (tags TagsList) HasSubsetOf(that Tags) bool {
 		return true
 	}
 	for _, tag := range tags {
+		// tags with an empty key come from malformed service keys and
+		// select nothing
+		if _, ok := tag[""]; ok {
+			continue
+		}
 		if tag.SubsetOf(that) {
 			return true
 		}
@@ -241,6 +255,16 @@ func (ports PortList) Get(name string) (*Port, bool) {
 	return nil, false
 }
 
+// GetByPort retrieves a port declaration by port value
+func (ports PortList) GetByPort(num int) (*Port, bool) {
+	for _, port := range ports {
+		if port.Port == num {
+			return port, true
+		}
+	}
+	return nil, false
+}
+
 // Key generates a unique string referencing service instances for a given port and tags.
 // The separator character must be exclusive to the regular expressions allowed in the
 // service declaration.
@@ -352,7 +376,9 @@ func (t Tags) String() string {
 func ParseTagString(s string) Tags {
 	tag := make(map[string]string)
 	for _, pair := range strings.Split(s, ",") {
-		kv := strings.Split(pair, "=")
+		// pairs without a key, e.g. from an empty tag string, are kept under
+		// the empty key, which never selects any instance
+		kv := strings.SplitN(pair, "=", 2)
 		if len(kv) > 1 {
 			tag[kv[0]] = kv[1]
 		} else {
@@ -14,7 +14,10 @@
 
 package model
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+)
 
 var validServiceKeys = map[string]struct {
 	service Service
@@ -154,3 +157,26 @@ func TestTags(t *testing.T) {
 		}
 	}
 }
+
+func TestParseServiceKeyMalformed(t *testing.T) {
+	cases := []struct {
+		key      string
+		hostname string
+		ports    int
+		tags     TagsList
+	}{
+		{key: "", hostname: "", ports: 1},
+		{key: "|||||", hostname: "", ports: 1},
+		{key: "svc|,,|", hostname: "svc", ports: 3},
+		{key: "svc|http|;", hostname: "svc", ports: 1, tags: TagsList{{"": ""}, {"": ""}}},
+		{key: "svc|http|=v,,a=b=c", hostname: "svc", ports: 1, tags: TagsList{{"": "", "a": "b=c"}}},
+		{key: "bücher|http|версия=v1", hostname: "bücher", ports: 1, tags: TagsList{{"версия": "v1"}}},
+	}
+	for _, c := range cases {
+		hostname, ports, tags := ParseServiceKey(c.key)
+		if hostname != c.hostname || len(ports) != c.ports || !reflect.DeepEqual(tags, c.tags) {
+			t.Errorf("ParseServiceKey(%q) => got %q, %d ports, %v, want %q, %d ports, %v",
+				c.key, hostname, len(ports), tags, c.hostname, c.ports, c.tags)
+		}
+	}
+}
@@ -15,12 +15,18 @@
 package model
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"unicode"
 
 	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/duration"
 
 	multierror "github.com/hashicorp/go-multierror"
 
@@ -39,6 +45,45 @@ var (
 	tagRegexp       = regexp.MustCompile("^" + qualifiedNameFmt + "$")
 )
 
+// subsetTagRegexp restricts the tags used to select a subset of service
+// instances, since the tags become part of Envoy cluster and stat names.
+// Dots are allowed as in the service hostnames in the same names.
+var subsetTagRegexp = regexp.MustCompile("^[-A-Za-z0-9_.]*$")
+
+// DefaultProtectedServices lists the Istio system services
+var DefaultProtectedServices = []string{"istio-manager", "istio-mixer", "istio-ingress", "istio-egress"}
+
+// ValidationOptions holds the configuration checks that are deployment policy
+// rather than fixed constraints of the proxy configuration
+type ValidationOptions struct {
+	// ProtectedServices lists the services that route rules cannot inject faults
+	// into, since faulting them can take down the mesh. Services are specified by
+	// a short name or a fully qualified host name.
+	ProtectedServices []string
+
+	// RequireQualifiedDestinations rejects route rules with destinations that are
+	// not fully qualified host names, since short names are ambiguous across namespaces
+	RequireQualifiedDestinations bool
+
+	// MaxFaultDelaySeconds is the fault delay above which validation warns that
+	// clients may hang and exhaust connections
+	MaxFaultDelaySeconds float64
+
+	// MaxTagKeyLength and MaxTagValueLength bound tag sizes, since tags become
+	// part of Envoy cluster names and endpoint metadata
+	MaxTagKeyLength   int
+	MaxTagValueLength int
+}
+
+// DefaultValidationOptions are the validation options of IstioConfig. The tag
+// limits follow the Kubernetes label limits.
+var DefaultValidationOptions = ValidationOptions{
+	ProtectedServices:    DefaultProtectedServices,
+	MaxFaultDelaySeconds: 300,
+	MaxTagKeyLength:      253,
+	MaxTagValueLength:    63,
+}
+
 // IsDNS1123Label tests for a string that conforms to the definition of a label in
 // DNS (RFC 1123).
 func IsDNS1123Label(value string) bool {
@@ -114,16 +159,39 @@ func (km KindMap) ValidateConfig(k *Key, obj interface{}) error {
 	return nil
 }
 
+// ConfigWarnings lists the warnings of the kind schema for a configuration
+// object. Objects of undeclared kinds or mismatched types have no warnings.
+func (km KindMap) ConfigWarnings(k *Key, obj proto.Message) []string {
+	if k == nil || obj == nil {
+		return nil
+	}
+	t, ok := km[k.Kind]
+	if !ok || t.Warnings == nil || proto.MessageName(obj) != t.MessageName {
+		return nil
+	}
+	return t.Warnings(obj)
+}
+
 // Validate ensures that the service object is well-defined
 func (s *Service) Validate() error {
 	var errs error
 	if len(s.Hostname) == 0 {
 		errs = multierror.Append(errs, fmt.Errorf("Invalid empty hostname"))
 	}
-	parts := strings.Split(s.Hostname, ".")
-	for _, part := range parts {
-		if !IsDNS1123Label(part) {
-			errs = multierror.Append(errs, fmt.Errorf("Invalid hostname part: %q", part))
+	if err := validateASCIIHostname(s.Hostname); err != nil {
+		errs = multierror.Append(errs, err)
+	} else {
+		// external services may match a domain with a leading wildcard label
+		parts := strings.Split(s.Hostname, ".")
+		for i, part := range parts {
+			switch {
+			case part == "*" && i == 0 && len(parts) > 1 && s.ExternalName != "":
+			case strings.Contains(part, "*"):
+				errs = multierror.Append(errs, fmt.Errorf("Invalid hostname %q: a wildcard is only allowed "+
+					"as the first of several labels of an external service", s.Hostname))
+			case !IsDNS1123Label(part):
+				errs = multierror.Append(errs, fmt.Errorf("Invalid hostname part: %q", part))
+			}
 		}
 	}
 
@@ -146,9 +214,149 @@ func (s *Service) Validate() error {
 			errs = multierror.Append(errs, fmt.Errorf("Invalid service port value %d for %q", port.Port, port.Name))
 		}
 	}
+
+	if s.ExternalName != "" {
+		if err := validateFQDN(s.ExternalName); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("Invalid external name: %v", err))
+		}
+		if s.Address != "" {
+			errs = multierror.Append(errs, fmt.Errorf("External service %q cannot have a load balancer address %q",
+				s.Hostname, s.Address))
+		}
+		// the proxies connect to the external name on the service port
+		for _, port := range s.Ports {
+			if port.Port == 0 {
+				errs = multierror.Append(errs, fmt.Errorf("External service port %q must be set", port.Name))
+			}
+		}
+	}
 	return errs
 }
 
+// ValidateInstancePorts flags endpoints (address and port) that are mapped to
+// service ports with conflicting protocols across instances, since a proxy
+// serves a single protocol on each endpoint port
+func ValidateInstancePorts(instances []*ServiceInstance) (errs error) {
+	type endpointKey struct {
+		address string
+		port    int
+	}
+	seen := make(map[endpointKey]*ServiceInstance)
+	for _, instance := range instances {
+		if instance.Endpoint.ServicePort == nil {
+			continue
+		}
+		key := endpointKey{address: instance.Endpoint.Address, port: instance.Endpoint.Port}
+		prior, ok := seen[key]
+		if !ok {
+			seen[key] = instance
+			continue
+		}
+		if prior.Endpoint.ServicePort.Protocol != instance.Endpoint.ServicePort.Protocol {
+			errs = multierror.Append(errs, fmt.Errorf("endpoint %s:%d is mapped to conflicting service ports "+
+				"%s:%d (%s) and %s:%d (%s)", key.address, key.port,
+				instanceHostname(prior), prior.Endpoint.ServicePort.Port, prior.Endpoint.ServicePort.Protocol,
+				instanceHostname(instance), instance.Endpoint.ServicePort.Port, instance.Endpoint.ServicePort.Protocol))
+		}
+	}
+	return
+}
+
+// ValidateProxyAdminPort checks that the proxy admin port is a valid port
+// that conflicts neither with the proxy listener port nor with the ports of
+// the service instances co-located with the proxy
+func ValidateProxyAdminPort(mesh *proxyconfig.ProxyMeshConfig, instances []*ServiceInstance) (errs error) {
+	port := int(mesh.ProxyAdminPort)
+	if port <= 0 || port > 65535 {
+		return fmt.Errorf("proxy admin port %d must be in range [1..65535]", port)
+	}
+	if port == int(mesh.ProxyListenPort) {
+		errs = multierror.Append(errs, fmt.Errorf("proxy admin port %d conflicts with the proxy listen port", port))
+	}
+	for _, instance := range instances {
+		if instance.Endpoint.Port == port {
+			errs = multierror.Append(errs, fmt.Errorf("proxy admin port %d conflicts with endpoint %s:%d of service %q",
+				port, instance.Endpoint.Address, instance.Endpoint.Port, instanceHostname(instance)))
+		}
+	}
+	return
+}
+
+// ValidateProxyMeshConfig checks the mesh settings that the proxy and the
+// discovery service consume directly, so that a misconfiguration fails
+// early instead of as an Envoy flag error
+func ValidateProxyMeshConfig(mesh *proxyconfig.ProxyMeshConfig) (errs error) {
+	if err := validateAddress(mesh.DiscoveryAddress); err != nil {
+		errs = multierror.Append(errs, fmt.Errorf("invalid discovery address: %v", err))
+	}
+	if mesh.MixerAddress != "" {
+		if err := validateAddress(mesh.MixerAddress); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid mixer address: %v", err))
+		}
+	}
+	if mesh.EgressProxyAddress != "" {
+		if err := validateAddress(mesh.EgressProxyAddress); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid egress proxy address: %v", err))
+		}
+	}
+
+	if port := mesh.ProxyListenPort; port <= 0 || port > 65535 {
+		errs = multierror.Append(errs, fmt.Errorf("proxy listen port %d must be in range [1..65535]", port))
+	}
+	if err := ValidateProxyAdminPort(mesh, nil); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	durations := []struct {
+		name  string
+		value *duration.Duration
+	}{
+		{"drain duration", mesh.DrainDuration},
+		{"parent shutdown duration", mesh.ParentShutdownDuration},
+		{"discovery refresh delay", mesh.DiscoveryRefreshDelay},
+		{"connect timeout", mesh.ConnectTimeout},
+	}
+	for _, d := range durations {
+		if d.value == nil {
+			continue
+		}
+		if dur, err := ptypes.Duration(d.value); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("invalid %s: %v", d.name, err))
+		} else if dur < 0 {
+			errs = multierror.Append(errs, fmt.Errorf("%s %v must be non-negative", d.name, dur))
+		}
+	}
+
+	if !IsDNS1123Label(mesh.IstioServiceCluster) {
+		errs = multierror.Append(errs, fmt.Errorf("istio service cluster %q must be a DNS-1123 label",
+			mesh.IstioServiceCluster))
+	}
+	return
+}
+
+// validateAddress checks that the address is a host and a port in range
+func validateAddress(addr string) error {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return fmt.Errorf("address %q has no host", addr)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("address %q port must be in range [1..65535]", addr)
+	}
+	return nil
+}
+
+func instanceHostname(instance *ServiceInstance) string {
+	if instance.Service == nil {
+		return ""
+	}
+	return instance.Service.Hostname
+}
+
 // Validate ensures that the service instance is well-defined
 func (instance *ServiceInstance) Validate() error {
 	var errs error
@@ -166,6 +374,12 @@ func (instance *ServiceInstance) Validate() error {
 		errs = multierror.Append(errs, fmt.Errorf("Negative port value: %d", instance.Endpoint.Port))
 	}
 
+	if instance.Endpoint.SNI != "" {
+		if err := validateFQDN(instance.Endpoint.SNI); err != nil {
+			errs = multierror.Append(errs, fmt.Errorf("Invalid endpoint SNI: %v", err))
+		}
+	}
+
 	port := instance.Endpoint.ServicePort
 	if port == nil {
 		errs = multierror.Append(errs, fmt.Errorf("Missing service port"))
@@ -188,20 +402,67 @@ func (instance *ServiceInstance) Validate() error {
 	return errs
 }
 
-// Validate ensures tag is well-formed
+// Validate ensures tag is well-formed, with the default tag length limits
 func (t Tags) Validate() error {
+	return DefaultValidationOptions.validateTags(t)
+}
+
+func (o ValidationOptions) validateTags(t Tags) error {
 	var errs error
 	for k, v := range t {
 		if !tagRegexp.MatchString(k) {
 			errs = multierror.Append(errs, fmt.Errorf("Invalid tag key: %q", k))
 		}
+		if len(k) > o.MaxTagKeyLength {
+			errs = multierror.Append(errs, fmt.Errorf("Tag key %q too long (max %d)", k, o.MaxTagKeyLength))
+		}
 		if !tagRegexp.MatchString(v) {
 			errs = multierror.Append(errs, fmt.Errorf("Invalid tag value: %q", v))
 		}
+		if len(v) > o.MaxTagValueLength {
+			errs = multierror.Append(errs, fmt.Errorf("Tag %q value %q too long (max %d)", k, v, o.MaxTagValueLength))
+		}
 	}
 	return errs
 }
 
+// ValidateSubsetTags checks that tags selecting a subset of service instances
+// only contain characters that are safe in Envoy cluster and stat names
+func ValidateSubsetTags(t Tags) (errs error) {
+	for k, v := range t {
+		if !subsetTagRegexp.MatchString(k) {
+			errs = multierror.Append(errs, fmt.Errorf("Invalid subset tag key: %q (must match %v)",
+				k, subsetTagRegexp))
+		}
+		if !subsetTagRegexp.MatchString(v) {
+			errs = multierror.Append(errs, fmt.Errorf("Invalid subset tag value: %q (must match %v)",
+				v, subsetTagRegexp))
+		}
+	}
+	return
+}
+
+// validateQualifiedDestination checks that a destination has at least the
+// service, namespace, and domain suffix labels
+func validateQualifiedDestination(destination string) error {
+	if len(strings.Split(destination, ".")) < 3 {
+		return fmt.Errorf("destination %q is not fully qualified (expected service.namespace.suffix)", destination)
+	}
+	return nil
+}
+
+// validateASCIIHostname rejects internationalized hostnames, which must be
+// given in their punycode (xn--) form to pass the DNS-1123 label checks
+func validateASCIIHostname(hostname string) error {
+	for _, r := range hostname {
+		if r > unicode.MaxASCII {
+			return fmt.Errorf("hostname %q contains non-ASCII characters, use its punycode (xn--) encoding instead",
+				hostname)
+		}
+	}
+	return nil
+}
+
 func validateFQDN(fqdn string) error {
 	if len(fqdn) > 255 {
 		return fmt.Errorf("domain name %q too long (max 255)", fqdn)
@@ -209,6 +470,9 @@ func validateFQDN(fqdn string) error {
 	if len(fqdn) == 0 {
 		return fmt.Errorf("empty domain name not allowed")
 	}
+	if err := validateASCIIHostname(fqdn); err != nil {
+		return err
+	}
 
 	for _, label := range strings.Split(fqdn, ".") {
 		if !IsDNS1123Label(label) {
@@ -220,7 +484,11 @@ func validateFQDN(fqdn string) error {
 }
 
 // ValidateMatchCondition validates a Match Condition
-func ValidateMatchCondition(mc *proxyconfig.MatchCondition) (errs error) {
+func ValidateMatchCondition(mc *proxyconfig.MatchCondition) error {
+	return DefaultValidationOptions.validateMatchCondition(mc)
+}
+
+func (o ValidationOptions) validateMatchCondition(mc *proxyconfig.MatchCondition) (errs error) {
 
 	if mc.Source != "" {
 		if err := validateFQDN(mc.Source); err != nil {
@@ -228,7 +496,7 @@ func ValidateMatchCondition(mc *proxyconfig.MatchCondition) (errs error) {
 		}
 	}
 
-	if err := Tags(mc.SourceTags).Validate(); err != nil {
+	if err := o.validateTags(mc.SourceTags); err != nil {
 		errs = multierror.Append(errs, err)
 	}
 
@@ -245,11 +513,65 @@ func ValidateMatchCondition(mc *proxyconfig.MatchCondition) (errs error) {
 		errs = multierror.Append(errs, fmt.Errorf("Istio does not support UDP protocol yet"))
 	}
 
-	// TODO We do not (yet) validate http_headers.
+	if err := validateHTTPHeaders(mc.HttpHeaders); err != nil {
+		errs = multierror.Append(errs, err)
+	}
 
 	return
 }
 
+// matchConditionWarnings lists the parts of a match condition that do not
+// affect the match
+func matchConditionWarnings(mc *proxyconfig.MatchCondition) []string {
+	out := make([]string, 0)
+	if isEmptyMatch(mc) {
+		out = append(out, "Match condition is empty: it matches all traffic")
+	}
+	for _, ma := range []*proxyconfig.L4MatchAttributes{mc.GetTcp(), mc.GetUdp()} {
+		if ma == nil {
+			continue
+		}
+		for _, overlap := range subnetOverlaps(ma.SourceSubnet) {
+			out = append(out, "Source "+overlap)
+		}
+		for _, overlap := range subnetOverlaps(ma.DestinationSubnet) {
+			out = append(out, "Destination "+overlap)
+		}
+	}
+	return out
+}
+
+// validateHTTPHeaders checks the header names and compiles the regex header
+// matches, since Envoy rejects the whole config on a malformed regex
+func validateHTTPHeaders(headers map[string]*proxyconfig.StringMatch) (errs error) {
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if name == "" || !tagRegexp.MatchString(name) {
+			errs = multierror.Append(errs, fmt.Errorf("Invalid header name: %q", name))
+		}
+		if regex, ok := headers[name].GetMatchType().(*proxyconfig.StringMatch_Regex); ok {
+			if _, err := regexp.Compile(regex.Regex); err != nil {
+				errs = multierror.Append(errs, fmt.Errorf("Invalid regex for header %q: %v", name, err))
+			}
+		}
+	}
+	return
+}
+
+// isEmptyMatch is true for a match condition without any source, tags,
+// subnets, or headers to match on
+func isEmptyMatch(mc *proxyconfig.MatchCondition) bool {
+	emptyL4 := func(ma *proxyconfig.L4MatchAttributes) bool {
+		return ma == nil || len(ma.SourceSubnet) == 0 && len(ma.DestinationSubnet) == 0
+	}
+	return mc.Source == "" && len(mc.SourceTags) == 0 && len(mc.HttpHeaders) == 0 &&
+		emptyL4(mc.GetTcp()) && emptyL4(mc.GetUdp())
+}
+
 // ValidateL4MatchAttributes validates L4 Match Attributes
 func ValidateL4MatchAttributes(ma *proxyconfig.L4MatchAttributes) (errs error) {
 
@@ -268,6 +590,43 @@ func ValidateL4MatchAttributes(ma *proxyconfig.L4MatchAttributes) (errs error) {
 	return
 }
 
+// subnetOverlaps lists the subnets that are fully shadowed by another subnet
+// in the same match list, and as such never affect the match
+func subnetOverlaps(subnets []string) []string {
+	nets := make([]*net.IPNet, 0, len(subnets))
+	for _, subnet := range subnets {
+		if !strings.Contains(subnet, "/") {
+			if strings.Contains(subnet, ":") {
+				subnet = subnet + "/128"
+			} else {
+				subnet = subnet + "/32"
+			}
+		}
+		_, ipnet, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil
+		}
+		nets = append(nets, ipnet)
+	}
+
+	out := make([]string, 0)
+	for i, a := range nets {
+		for j, b := range nets {
+			if i == j {
+				continue
+			}
+			aOnes, _ := a.Mask.Size()
+			bOnes, _ := b.Mask.Size()
+			// b contains a; identical subnets are reported once
+			if b.Contains(a.IP) && (bOnes < aOnes || (bOnes == aOnes && j < i)) {
+				out = append(out, fmt.Sprintf("subnet %q is shadowed by subnet %q", subnets[i], subnets[j]))
+				break
+			}
+		}
+	}
+	return out
+}
+
 func validatePercent(err error, val int32, label string) error {
 	if val < 0 || val > 100 {
 		err = multierror.Append(err, fmt.Errorf("%v must be in range 0..100", label))
@@ -283,7 +642,11 @@ func validateFloatPercent(err error, val float32, label string) error {
 }
 
 // ValidateDestinationWeight validates DestinationWeight
-func ValidateDestinationWeight(dw *proxyconfig.DestinationWeight) (errs error) {
+func ValidateDestinationWeight(dw *proxyconfig.DestinationWeight) error {
+	return DefaultValidationOptions.validateDestinationWeight(dw)
+}
+
+func (o ValidationOptions) validateDestinationWeight(dw *proxyconfig.DestinationWeight) (errs error) {
 
 	if dw.Destination != "" {
 		if err := validateFQDN(dw.Destination); err != nil {
@@ -291,7 +654,7 @@ func ValidateDestinationWeight(dw *proxyconfig.DestinationWeight) (errs error) {
 		}
 	}
 
-	if err := Tags(dw.Tags).Validate(); err != nil {
+	if err := o.validateTags(dw.Tags); err != nil {
 		errs = multierror.Append(errs, err)
 	}
 
@@ -366,10 +729,37 @@ func ValidateL4Fault(fault *proxyconfig.L4FaultInjection) (errs error) {
 }
 
 func validateSubnet(subnet string) error {
-	// The current implementation only supports IP v4 addresses
+	// IP v6 addresses always contain a colon, IP v4 addresses never do
+	if strings.Contains(subnet, ":") {
+		return validateIPv6Subnet(subnet)
+	}
 	return validateIPv4Subnet(subnet)
 }
 
+// validateIPv6Subnet validates that a string is an IP v6 address, optionally in "CIDR notation"
+func validateIPv6Subnet(subnet string) error {
+
+	// We expect a string such as 2001:db8::/32 or just 2001:db8::1
+	parts := strings.Split(subnet, "/")
+	if len(parts) > 2 {
+		return fmt.Errorf("%q is not valid CIDR notation", subnet)
+	}
+
+	var errs error
+
+	if len(parts) == 2 {
+		if bits, err := strconv.Atoi(parts[1]); err != nil || bits < 0 || bits > 128 {
+			errs = multierror.Append(errs, fmt.Errorf("/%v is not a valid CIDR block", parts[1]))
+		}
+	}
+
+	if ip := net.ParseIP(parts[0]); ip == nil || !strings.Contains(parts[0], ":") {
+		errs = multierror.Append(errs, fmt.Errorf("%q is not a valid IP address", parts[0]))
+	}
+
+	return errs
+}
+
 // validateIPv4Subnet validates that a string in "CIDR notation" or "Dot-decimal notation"
 func validateIPv4Subnet(subnet string) error {
 
@@ -438,6 +828,30 @@ func validateDelay(delay *proxyconfig.HTTPFaultInjection_Delay) (errs error) {
 	return
 }
 
+// httpFaultWarnings lists the faults that are never injected or that inject
+// delays above max seconds
+func httpFaultWarnings(fault *proxyconfig.HTTPFaultInjection, max float64) []string {
+	out := make([]string, 0)
+	if delay := fault.GetDelay(); delay != nil {
+		if delay.Percent == 0 {
+			out = append(out, "Fault delay percent is 0: the delay is never injected")
+		}
+		if exceedsMaxDelay(delay, max) {
+			out = append(out, fmt.Sprintf("Fault delay of %vs exceeds %vs: clients may hang and exhaust connections",
+				delay.GetFixedDelaySeconds()+delay.GetExponentialDelaySeconds(), max))
+		}
+	}
+	if abort := fault.GetAbort(); abort != nil && abort.Percent == 0 {
+		out = append(out, "Fault abort percent is 0: the abort is never injected")
+	}
+	return out
+}
+
+// exceedsMaxDelay is true if the fixed or exponential delay is above max seconds
+func exceedsMaxDelay(delay *proxyconfig.HTTPFaultInjection_Delay, max float64) bool {
+	return delay.GetFixedDelaySeconds() > max || delay.GetExponentialDelaySeconds() > max
+}
+
 func validateAbortHTTPStatus(httpStatus *proxyconfig.HTTPFaultInjection_Abort_HttpStatus) (errs error) {
 
 	if httpStatus.HttpStatus < 0 || httpStatus.HttpStatus > 600 {
@@ -500,6 +914,29 @@ func validateThrottle(throttle *proxyconfig.L4FaultInjection_Throttle) (errs err
 	return
 }
 
+// maxThrottleAfterBytes is the throttle_after_bytes above which a connection is
+// unlikely to ever be throttled
+const maxThrottleAfterBytes = 1 << 40
+
+// throttleFootguns lists throttle settings that are individually valid but
+// likely misconfigured
+func throttleFootguns(throttle *proxyconfig.L4FaultInjection_Throttle) []string {
+	var out []string
+	if throttle.DownstreamLimitBps == 0 && throttle.UpstreamLimitBps == 0 {
+		out = append(out, "sets neither downstream_limit_bps nor upstream_limit_bps: nothing is throttled")
+	}
+	if throttle.DownstreamLimitBps > 0 && throttle.UpstreamLimitBps > 0 &&
+		throttle.UpstreamLimitBps < throttle.DownstreamLimitBps {
+		out = append(out, fmt.Sprintf("upstream_limit_bps %d is below downstream_limit_bps %d",
+			throttle.UpstreamLimitBps, throttle.DownstreamLimitBps))
+	}
+	if throttle.GetThrottleAfterBytes() > maxThrottleAfterBytes {
+		out = append(out, fmt.Sprintf("throttle_after_bytes %v exceeds %v: connections are unlikely to be throttled",
+			throttle.GetThrottleAfterBytes(), float64(maxThrottleAfterBytes)))
+	}
+	return out
+}
+
 // ValidateLoadBalancing validates Load Balancing
 func ValidateLoadBalancing(lb *proxyconfig.LoadBalancing) (errs error) {
 
@@ -541,11 +978,48 @@ func ValidateCircuitBreaker(cb *proxyconfig.CircuitBreaker) (errs error) {
 	return
 }
 
+// circuitBreakerFootguns lists threshold combinations that are individually
+// valid but disrupt traffic. Zero thresholds are left out of the generated
+// Envoy config, falling back to Envoy defaults, so they never block traffic.
+func circuitBreakerFootguns(simple *proxyconfig.CircuitBreaker_SimpleCircuitBreakerPolicy) []string {
+	var out []string
+	if simple.HttpMaxEjectionPercent == 100 && simple.HttpConsecutiveErrors == 1 {
+		out = append(out, "http_max_ejection_percent 100 with http_consecutive_errors 1: "+
+			"a single error per host can eject every host")
+	}
+	if simple.HttpMaxRequestsPerConnection == 1 {
+		out = append(out, "http_max_requests_per_connection 1: connections are never reused")
+	}
+	return out
+}
+
+// ValidateProtectedFault rejects a route rule that injects faults into one of
+// the protected services
+func ValidateProtectedFault(rule *proxyconfig.RouteRule, protected []string) error {
+	if rule.HttpFault == nil && rule.L4Fault == nil {
+		return nil
+	}
+	for _, service := range protected {
+		if rule.Destination == service || strings.HasPrefix(rule.Destination, service+".") {
+			return fmt.Errorf("route rule cannot inject faults into protected service %q", service)
+		}
+	}
+	return nil
+}
+
 func validateWeights(routes []*proxyconfig.DestinationWeight, defaultDestination string) (errs error) {
 
 	// Sum weights
 	sum := 0
 	for _, destWeight := range routes {
+		if destWeight.Weight < 0 {
+			destination := destWeight.Destination
+			if destination == "" {
+				destination = defaultDestination
+			}
+			errs = multierror.Append(errs, fmt.Errorf("Route weight for destination %q with tags %v is negative: %d",
+				destination, Tags(destWeight.Tags), destWeight.Weight))
+		}
 		sum = sum + int(destWeight.Weight)
 	}
 
@@ -562,9 +1036,187 @@ func validateWeights(routes []*proxyconfig.DestinationWeight, defaultDestination
 	return
 }
 
-// ValidateRouteRule checks routing rules
+// unreachableRoutes lists destinations with a zero weight in a split across
+// several destinations, since they never receive any traffic
+func unreachableRoutes(routes []*proxyconfig.DestinationWeight, defaultDestination string) []string {
+	var out []string
+	if len(routes) < 2 {
+		return out
+	}
+	for _, destWeight := range routes {
+		if destWeight.Weight == 0 {
+			destination := destWeight.Destination
+			if destination == "" {
+				destination = defaultDestination
+			}
+			out = append(out, fmt.Sprintf("destination %q with tags %v is 0: it is unreachable",
+				destination, Tags(destWeight.Tags)))
+		}
+	}
+	return out
+}
+
+// ValidateRouteRule checks routing rules with the default validation options
 func ValidateRouteRule(msg proto.Message) error {
+	return DefaultValidationOptions.ValidateRouteRule(msg)
+}
 
+// ValidateRouteRule checks routing rules
+func (o ValidationOptions) ValidateRouteRule(msg proto.Message) error {
+	errs := o.validateRouteRule(msg)
+	value, ok := msg.(*proxyconfig.RouteRule)
+	if !ok {
+		return errs
+	}
+
+	// route tags are encoded in the generated cluster names
+	for _, destWeight := range value.Route {
+		if err := ValidateSubsetTags(destWeight.Tags); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+	}
+	if err := validateDuplicateRoutes(value); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
+	if o.RequireQualifiedDestinations {
+		if err := validateQualifiedDestination(value.Destination); err != nil {
+			errs = multierror.Append(errs, err)
+		}
+		for _, destWeight := range value.Route {
+			if destWeight.Destination == "" {
+				continue
+			}
+			if err := validateQualifiedDestination(destWeight.Destination); err != nil {
+				errs = multierror.Append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
+
+// RouteRuleWarnings lists the settings of a route rule that are valid but
+// likely misconfigured, with the default validation options
+func RouteRuleWarnings(msg proto.Message) []string {
+	return DefaultValidationOptions.RouteRuleWarnings(msg)
+}
+
+// RouteRuleWarnings lists the settings of a route rule that are valid but
+// likely misconfigured
+func (o ValidationOptions) RouteRuleWarnings(msg proto.Message) []string {
+	value, ok := msg.(*proxyconfig.RouteRule)
+	if !ok {
+		return nil
+	}
+	out := o.ruleWarnings(value)
+	if !hasRouteBehavior(value) {
+		out = append(out, fmt.Sprintf("Route rule for destination %q has no match, route, timeout, retry, "+
+			"or fault: it has no effect", value.Destination))
+	}
+	for _, overlap := range overlappingRoutes(value) {
+		out = append(out, fmt.Sprintf("Route rule for destination %q: %s", value.Destination, overlap))
+	}
+	return out
+}
+
+// IngressRuleWarnings lists the settings of an ingress rule that are valid but
+// likely misconfigured, with the default validation options
+func IngressRuleWarnings(msg proto.Message) []string {
+	return DefaultValidationOptions.IngressRuleWarnings(msg)
+}
+
+// IngressRuleWarnings lists the settings of an ingress rule that are valid but
+// likely misconfigured
+func (o ValidationOptions) IngressRuleWarnings(msg proto.Message) []string {
+	value, ok := msg.(*proxyconfig.RouteRule)
+	if !ok {
+		return nil
+	}
+	return o.ruleWarnings(value)
+}
+
+// ruleWarnings lists the warnings shared by route and ingress rules
+func (o ValidationOptions) ruleWarnings(rule *proxyconfig.RouteRule) []string {
+	out := make([]string, 0)
+	if rule.Match != nil {
+		out = append(out, matchConditionWarnings(rule.Match)...)
+	}
+	for _, unreachable := range unreachableRoutes(rule.Route, rule.Destination) {
+		out = append(out, "Route weight for "+unreachable)
+	}
+	if rule.HttpFault != nil {
+		out = append(out, httpFaultWarnings(rule.HttpFault, o.MaxFaultDelaySeconds)...)
+	}
+	if throttle := rule.L4Fault.GetThrottle(); throttle != nil {
+		for _, footgun := range throttleFootguns(throttle) {
+			out = append(out, "Throttle fault "+footgun)
+		}
+	}
+	return out
+}
+
+// overlappingRoutes lists the weighted routes of a rule whose tags can select
+// the same instances. Each route is a separate cluster and endpoint weights are
+// not adjusted for the split, so a shared endpoint receives the traffic of both
+// routes and the effective split differs from the declared weights.
+func overlappingRoutes(rule *proxyconfig.RouteRule) []string {
+	var out []string
+	for i := 0; i < len(rule.Route); i++ {
+		for j := i + 1; j < len(rule.Route); j++ {
+			a, b := rule.Route[i], rule.Route[j]
+			if routeDestination(rule, a) != routeDestination(rule, b) || !compatibleTags(a.Tags, b.Tags) ||
+				Tags(a.Tags).Equals(b.Tags) {
+				continue
+			}
+			out = append(out, fmt.Sprintf("routes with tags %v (weight %d) and %v (weight %d) may share instances",
+				Tags(a.Tags), a.Weight, Tags(b.Tags), b.Weight))
+		}
+	}
+	return out
+}
+
+// validateDuplicateRoutes checks that no two routes of a rule send traffic to
+// the same destination and tags, since their weights would silently add up
+func validateDuplicateRoutes(rule *proxyconfig.RouteRule) (errs error) {
+	seen := make(map[string]bool)
+	for _, route := range rule.Route {
+		destination := routeDestination(rule, route)
+		key := destination + "|" + Tags(route.Tags).String()
+		if seen[key] {
+			errs = multierror.Append(errs, fmt.Errorf("Route rule for destination %q has duplicate routes "+
+				"to destination %q with tags %v", rule.Destination, destination, Tags(route.Tags)))
+		}
+		seen[key] = true
+	}
+	return
+}
+
+func routeDestination(rule *proxyconfig.RouteRule, route *proxyconfig.DestinationWeight) string {
+	if route.Destination != "" {
+		return route.Destination
+	}
+	return rule.Destination
+}
+
+// compatibleTags is true if an instance can carry both tag sets
+func compatibleTags(a, b map[string]string) bool {
+	for k, v := range a {
+		if w, ok := b[k]; ok && v != w {
+			return false
+		}
+	}
+	return true
+}
+
+// hasRouteBehavior is false for a route rule that only names a destination,
+// which leaves the default routing unchanged
+func hasRouteBehavior(rule *proxyconfig.RouteRule) bool {
+	return rule.Match != nil || len(rule.Route) > 0 || rule.HttpReqTimeout != nil ||
+		rule.HttpReqRetries != nil || rule.HttpFault != nil || rule.L4Fault != nil
+}
+
+func (o ValidationOptions) validateRouteRule(msg proto.Message) error {
 	value, ok := msg.(*proxyconfig.RouteRule)
 	if !ok {
 		return fmt.Errorf("cannot cast to routing rule")
@@ -581,14 +1233,14 @@ func ValidateRouteRule(msg proto.Message) error {
 	// We don't validate precedence because any int32 is legal
 
 	if value.Match != nil {
-		if err := ValidateMatchCondition(value.Match); err != nil {
+		if err := o.validateMatchCondition(value.Match); err != nil {
 			errs = multierror.Append(errs, err)
 		}
 	}
 
 	if value.Route != nil {
 		for _, destWeight := range value.Route {
-			if err := ValidateDestinationWeight(destWeight); err != nil {
+			if err := o.validateDestinationWeight(destWeight); err != nil {
 				errs = multierror.Append(errs, err)
 			}
 		}
@@ -622,17 +1274,53 @@ func ValidateRouteRule(msg proto.Message) error {
 		errs = multierror.Append(errs, fmt.Errorf("L4 faults are not implemented"))
 	}
 
+	if err := ValidateProtectedFault(value, o.ProtectedServices); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+
 	return errs
 }
 
-// ValidateIngressRule checks ingress rules
+// ValidateIngressRule checks ingress rules with the default validation options
 func ValidateIngressRule(msg proto.Message) error {
+	return DefaultValidationOptions.ValidateIngressRule(msg)
+}
+
+// ValidateIngressRule checks ingress rules
+func (o ValidationOptions) ValidateIngressRule(msg proto.Message) error {
 	// TODO: Add ingress-only validation checks, if any?
-	return ValidateRouteRule(msg)
+	return o.validateRouteRule(msg)
 }
 
-// ValidateDestinationPolicy checks proxy policies
+// CheckDestinationPolicyEndpoints reports whether an endpoint of the policy
+// destination carries the policy tags, since otherwise the policy is never applied
+func CheckDestinationPolicyEndpoints(policy *proxyconfig.DestinationPolicy, discovery ServiceDiscovery) bool {
+	if len(policy.Tags) == 0 {
+		return true
+	}
+	service, ok := discovery.GetService(policy.Destination)
+	if ok && len(discovery.Instances(service.Hostname, service.Ports.GetNames(), TagsList{policy.Tags})) > 0 {
+		return true
+	}
+	return false
+}
+
+// CheckServiceInstances reports whether a service with ports has instances,
+// since otherwise its clusters and routes blackhole traffic
+func CheckServiceInstances(service *Service, discovery ServiceDiscovery) bool {
+	if len(service.Ports) == 0 || len(discovery.Instances(service.Hostname, service.Ports.GetNames(), nil)) > 0 {
+		return true
+	}
+	return false
+}
+
+// ValidateDestinationPolicy checks proxy policies with the default validation options
 func ValidateDestinationPolicy(msg proto.Message) error {
+	return DefaultValidationOptions.ValidateDestinationPolicy(msg)
+}
+
+// ValidateDestinationPolicy checks proxy policies
+func (o ValidationOptions) ValidateDestinationPolicy(msg proto.Message) error {
 	value, ok := msg.(*proxyconfig.DestinationPolicy)
 	if !ok {
 		return fmt.Errorf("Cannot cast to destination policy")
@@ -649,7 +1337,7 @@ func ValidateDestinationPolicy(msg proto.Message) error {
 		}
 	}
 
-	if err := Tags(value.Tags).Validate(); err != nil {
+	if err := o.validateTags(value.Tags); err != nil {
 		errs = multierror.Append(errs, err)
 	}
 
@@ -667,3 +1355,407 @@ func ValidateDestinationPolicy(msg proto.Message) error {
 
 	return errs
 }
+
+// DestinationPolicyWarnings lists the settings of a destination policy that
+// are valid but likely misconfigured
+func DestinationPolicyWarnings(msg proto.Message) []string {
+	value, ok := msg.(*proxyconfig.DestinationPolicy)
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0)
+	if strings.Contains(value.Destination, "*") {
+		// a wildcard policy would apply to every matching service at once
+		out = append(out, fmt.Sprintf("Destination policy targets wildcard destination %q: "+
+			"it applies to every matching service", value.Destination))
+	}
+	if simple := value.GetCircuitBreaker().GetSimpleCb(); simple != nil {
+		for _, footgun := range circuitBreakerFootguns(simple) {
+			out = append(out, "Circuit breaker "+footgun)
+		}
+	}
+	return out
+}
+
+// ingressPath is the host and URI path matched by an ingress rule
+type ingressPath struct {
+	host   string
+	path   string
+	prefix bool
+}
+
+func ingressPathOf(rule *proxyconfig.RouteRule) ingressPath {
+	out := ingressPath{host: "*", path: "/", prefix: true}
+	if rule.Match == nil {
+		return out
+	}
+	if authority, ok := rule.Match.HttpHeaders["authority"]; ok && authority.GetExact() != "" {
+		out.host = authority.GetExact()
+	}
+	if uri, ok := rule.Match.HttpHeaders["uri"]; ok {
+		switch m := uri.GetMatchType().(type) {
+		case *proxyconfig.StringMatch_Exact:
+			out.path, out.prefix = m.Exact, false
+		case *proxyconfig.StringMatch_Prefix:
+			out.path = m.Prefix
+		}
+	}
+	return out
+}
+
+// sortedKeys returns the rule keys in a stable order
+func sortedKeys(rules map[Key]*proxyconfig.RouteRule) []Key {
+	keys := make([]Key, 0, len(rules))
+	for key := range rules {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+	return keys
+}
+
+// ValidateIngressRuleConflicts checks that no two ingress rules match the same
+// host and path with different destinations
+func ValidateIngressRuleConflicts(rules map[Key]*proxyconfig.RouteRule) (errs error) {
+	keys := sortedKeys(rules)
+	for i, a := range keys {
+		for _, b := range keys[i+1:] {
+			if ingressPathOf(rules[a]) == ingressPathOf(rules[b]) &&
+				rules[a].Destination != rules[b].Destination {
+				errs = multierror.Append(errs, fmt.Errorf("ingress rules %v and %v match the same host and path "+
+					"with different destinations %q and %q", a, b, rules[a].Destination, rules[b].Destination))
+			}
+		}
+	}
+	return
+}
+
+// ValidateIngressRouteCollisions checks that no ingress rule served on the
+// ingress listener port claims the same host and port as the destination of
+// a route rule, since the ingress and the sidecar proxies would then route
+// the same host and port differently
+func ValidateIngressRouteCollisions(ingress, routes map[Key]*proxyconfig.RouteRule, port int,
+	discovery ServiceDiscovery) (errs error) {
+	routeKeys := sortedKeys(routes)
+	for _, a := range sortedKeys(ingress) {
+		host, hostPort := ingressPathOf(ingress[a]).host, port
+		if h, p, err := net.SplitHostPort(host); err == nil {
+			if hostPort, err = strconv.Atoi(p); err != nil {
+				continue
+			}
+			host = h
+		}
+		if host == "*" {
+			continue
+		}
+		service, ok := discovery.GetService(host)
+		if !ok {
+			continue
+		}
+		if _, exists := service.Ports.GetByPort(hostPort); !exists {
+			continue
+		}
+		for _, b := range routeKeys {
+			if routes[b].Destination == service.Hostname {
+				errs = multierror.Append(errs, fmt.Errorf("ingress rule %v and route rule %v both bind host %q port %d",
+					a, b, service.Hostname, hostPort))
+			}
+		}
+	}
+	return
+}
+
+// ingressRuleOverlaps lists the ingress rules for the same host where a prefix
+// path shadows part of another rule's path, e.g. /api and /api/v1
+func ingressRuleOverlaps(rules map[Key]*proxyconfig.RouteRule) []string {
+	out := make([]string, 0)
+	keys := sortedKeys(rules)
+	for _, a := range keys {
+		pa := ingressPathOf(rules[a])
+		if !pa.prefix {
+			continue
+		}
+		for _, b := range keys {
+			pb := ingressPathOf(rules[b])
+			if a == b || pa.host != pb.host || pa.path == pb.path || !strings.HasPrefix(pb.path, pa.path) {
+				continue
+			}
+			out = append(out, fmt.Sprintf("ingress rule %v prefix %q overlaps ingress rule %v path %q",
+				a, pa.path, b, pb.path))
+		}
+	}
+	return out
+}
+
+// RouteRuleShadows lists the route rules for the same destination where a
+// rule with a broader match has a higher precedence than a more specific rule,
+// so the specific rule never applies
+func RouteRuleShadows(rules map[Key]*proxyconfig.RouteRule) []string {
+	out := make([]string, 0)
+	keys := sortedKeys(rules)
+	for _, a := range keys {
+		for _, b := range keys {
+			ra, rb := rules[a], rules[b]
+			if a == b || ra.Destination != rb.Destination || ra.Precedence <= rb.Precedence ||
+				!matchCovers(ra.Match, rb.Match) {
+				continue
+			}
+			out = append(out, fmt.Sprintf("route rule %v (precedence %d) shadows more specific route rule %v "+
+				"(precedence %d) for destination %q", a, ra.Precedence, b, rb.Precedence, ra.Destination))
+		}
+	}
+	return out
+}
+
+// StackedFaultRules lists the pairs of route rules for the same destination
+// with overlapping matches that both inject faults, since the effective fault
+// rate of stacked rules is not obvious from either rule alone
+func StackedFaultRules(rules map[Key]*proxyconfig.RouteRule) []string {
+	out := make([]string, 0)
+	keys := make([]Key, 0, len(rules))
+	for _, key := range sortedKeys(rules) {
+		if rule := rules[key]; rule.HttpFault != nil || rule.L4Fault != nil {
+			keys = append(keys, key)
+		}
+	}
+	for i, a := range keys {
+		for _, b := range keys[i+1:] {
+			ra, rb := rules[a], rules[b]
+			if ra.Destination != rb.Destination || !(matchCovers(ra.Match, rb.Match) || matchCovers(rb.Match, ra.Match)) {
+				continue
+			}
+			out = append(out, fmt.Sprintf("route rules %v and %v both inject faults for destination %q "+
+				"with overlapping matches", a, b, ra.Destination))
+		}
+	}
+	return out
+}
+
+// ValidateRouteRuleCycles checks that the route rules do not redirect traffic
+// in a loop, where a rule for one destination routes to a second destination
+// whose rules lead back to the first one
+func ValidateRouteRuleCycles(rules map[Key]*proxyconfig.RouteRule) (errs error) {
+	type edge struct {
+		to  string
+		key Key
+	}
+	graph := make(map[string][]edge)
+	for _, key := range sortedKeys(rules) {
+		rule := rules[key]
+		for _, route := range rule.Route {
+			if to := routeDestination(rule, route); to != rule.Destination {
+				graph[rule.Destination] = append(graph[rule.Destination], edge{to: to, key: key})
+			}
+		}
+	}
+	nodes := make([]string, 0, len(graph))
+	for node := range graph {
+		nodes = append(nodes, node)
+	}
+	sort.Strings(nodes)
+
+	// depth-first search, where a back edge to a node on the path closes a cycle
+	const (
+		unvisited = iota
+		onPath
+		done
+	)
+	state := make(map[string]int)
+	var path []string
+	var keys []Key
+	var visit func(node string)
+	visit = func(node string) {
+		state[node] = onPath
+		path = append(path, node)
+		for _, e := range graph[node] {
+			keys = append(keys, e.key)
+			switch state[e.to] {
+			case unvisited:
+				visit(e.to)
+			case onPath:
+				start := 0
+				for path[start] != e.to {
+					start++
+				}
+				cycle := append(append([]string{}, path[start:]...), e.to)
+				errs = multierror.Append(errs, fmt.Errorf("route rules %v form a loop: %s",
+					keys[start:], strings.Join(cycle, " -> ")))
+			}
+			keys = keys[:len(keys)-1]
+		}
+		path = path[:len(path)-1]
+		state[node] = done
+	}
+	for _, node := range nodes {
+		if state[node] == unvisited {
+			visit(node)
+		}
+	}
+	return
+}
+
+// matchCovers is true if every request matching b also matches a
+func matchCovers(a, b *proxyconfig.MatchCondition) bool {
+	if a == nil || isEmptyMatch(a) {
+		return true
+	}
+	if b == nil {
+		return false
+	}
+	if a.Source != "" && a.Source != b.Source {
+		return false
+	}
+	if !Tags(a.SourceTags).SubsetOf(b.SourceTags) {
+		return false
+	}
+	for name, match := range a.HttpHeaders {
+		if !proto.Equal(match, b.HttpHeaders[name]) {
+			return false
+		}
+	}
+	// subnets are not compared by containment
+	return proto.Equal(a.GetTcp(), b.GetTcp()) && proto.Equal(a.GetUdp(), b.GetUdp())
+}
+
+// ValidationResult is the outcome of validating a single configuration object
+type ValidationResult struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+// ValidationReport validates a set of configuration objects and reports
+// the results in JSON keyed by the configuration keys
+func (km KindMap) ValidationReport(configs map[Key]proto.Message) ([]byte, error) {
+	report := make(map[string]*ValidationResult, len(configs))
+	for key, config := range configs {
+		key := key
+		result := &ValidationResult{
+			Errors:   make([]string, 0),
+			Warnings: make([]string, 0),
+		}
+		result.Errors = appendErrors(result.Errors, km.ValidateConfig(&key, config))
+		result.Warnings = append(result.Warnings, km.ConfigWarnings(&key, config)...)
+		result.Valid = len(result.Errors) == 0
+		report[key.String()] = result
+	}
+	return json.MarshalIndent(report, "", "  ")
+}
+
+// appendErrors flattens a (multi) error into a list of messages
+func appendErrors(out []string, err error) []string {
+	if err == nil {
+		return out
+	}
+	if merr, ok := err.(*multierror.Error); ok {
+		for _, e := range merr.Errors {
+			out = append(out, e.Error())
+		}
+		return out
+	}
+	return append(out, err.Error())
+}
+
+// RegistryCheckKey holds the findings that involve several objects at once,
+// such as conflicting ingress rules or shadowed route rules
+const RegistryCheckKey = "registry"
+
+// Check validates the whole model: the services and instances in the
+// discovery and all configuration objects of the given kinds in the registry,
+// including references from the configuration to services. The results are
+// keyed by configuration keys, "service/<hostname>", and
+// "instance/<hostname>/<address>:<port>".
+func (i *IstioRegistry) Check(kinds KindMap, discovery ServiceDiscovery) map[string]*ValidationResult {
+	report := make(map[string]*ValidationResult)
+	resultFor := func(key string) *ValidationResult {
+		result, ok := report[key]
+		if !ok {
+			result = &ValidationResult{
+				Errors:   make([]string, 0),
+				Warnings: make([]string, 0),
+			}
+			report[key] = result
+		}
+		return result
+	}
+
+	// services and instances
+	all := make([]*ServiceInstance, 0)
+	for _, service := range discovery.Services() {
+		result := resultFor("service/" + service.Hostname)
+		result.Errors = appendErrors(result.Errors, service.Validate())
+		instances := discovery.Instances(service.Hostname, service.Ports.GetNames(), nil)
+		if len(service.Ports) > 0 && len(instances) == 0 {
+			result.Warnings = append(result.Warnings, "service has no instances")
+		}
+		for _, instance := range instances {
+			key := fmt.Sprintf("instance/%s/%s:%d", service.Hostname, instance.Endpoint.Address, instance.Endpoint.Port)
+			result := resultFor(key)
+			result.Errors = appendErrors(result.Errors, instance.Validate())
+		}
+		all = append(all, instances...)
+	}
+	registry := resultFor(RegistryCheckKey)
+	registry.Errors = appendErrors(registry.Errors, ValidateInstancePorts(all))
+
+	// configuration objects and their references to services
+	for _, kind := range kinds.Kinds() {
+		configs, err := i.List(kind, "")
+		if err != nil {
+			registry.Errors = append(registry.Errors, fmt.Sprintf("cannot list %s: %v", kind, err))
+			continue
+		}
+		for key, config := range configs {
+			key := key
+			result := resultFor(key.String())
+			result.Errors = appendErrors(result.Errors, kinds.ValidateConfig(&key, config))
+			result.Warnings = append(result.Warnings, kinds.ConfigWarnings(&key, config)...)
+			switch value := config.(type) {
+			case *proxyconfig.RouteRule:
+				result.Errors = append(result.Errors, danglingRouteDestinations(value, discovery)...)
+			case *proxyconfig.DestinationPolicy:
+				if _, ok := discovery.GetService(value.Destination); !ok {
+					result.Errors = append(result.Errors,
+						fmt.Sprintf("destination %q is not a known service", value.Destination))
+				} else if !CheckDestinationPolicyEndpoints(value, discovery) {
+					result.Warnings = append(result.Warnings,
+						fmt.Sprintf("no endpoints of %q have tags %v", value.Destination, Tags(value.Tags)))
+				}
+			}
+		}
+	}
+
+	// findings across configuration objects
+	ingress := i.IngressRules("")
+	registry.Errors = appendErrors(registry.Errors, ValidateIngressRuleConflicts(ingress))
+	registry.Warnings = append(registry.Warnings, ingressRuleOverlaps(ingress)...)
+	routes := i.RouteRules("")
+	registry.Errors = appendErrors(registry.Errors, ValidateRouteRuleCycles(routes))
+	registry.Warnings = append(registry.Warnings, RouteRuleShadows(routes)...)
+	registry.Warnings = append(registry.Warnings, StackedFaultRules(routes)...)
+
+	for _, result := range report {
+		result.Valid = len(result.Errors) == 0
+	}
+	return report
+}
+
+// danglingRouteDestinations lists the destinations of a rule that are not
+// known services
+func danglingRouteDestinations(rule *proxyconfig.RouteRule, discovery ServiceDiscovery) []string {
+	out := make([]string, 0)
+	seen := make(map[string]bool)
+	destinations := []string{rule.Destination}
+	for _, route := range rule.Route {
+		destinations = append(destinations, routeDestination(rule, route))
+	}
+	for _, destination := range destinations {
+		if seen[destination] {
+			continue
+		}
+		seen[destination] = true
+		if _, ok := discovery.GetService(destination); !ok {
+			out = append(out, fmt.Sprintf("destination %q is not a known service", destination))
+		}
+	}
+	return out
+}
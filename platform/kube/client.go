@@ -262,6 +262,9 @@ func (cl *Client) Post(key model.Key, v proto.Message) error {
 	if err := cl.mapping.ValidateConfig(&key, v); err != nil {
 		return err
 	}
+	for _, warning := range cl.mapping.ConfigWarnings(&key, v) {
+		glog.Warningf("%v: %s", key, warning)
+	}
 
 	if cl.mapping[key.Kind].Internal {
 		return fmt.Errorf("unsupported operation: cannot post a derived config element of type %q", key.Kind)
@@ -289,6 +292,9 @@ func (cl *Client) Put(key model.Key, v proto.Message) error {
 	if err := cl.mapping.ValidateConfig(&key, v); err != nil {
 		return err
 	}
+	for _, warning := range cl.mapping.ConfigWarnings(&key, v) {
+		glog.Warningf("%v: %s", key, warning)
+	}
 
 	if cl.mapping[key.Kind].Internal {
 		return fmt.Errorf("unsupported operation: cannot put a derived config element of type %q", key.Kind)
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"reflect"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -81,6 +82,10 @@ type Controller struct {
 	ingresses cacheHandler
 
 	pods *PodCache
+
+	// ready is closed once after the initial state synchronization
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
 type cacheHandler struct {
@@ -96,6 +101,7 @@ func NewController(client *Client, config ControllerConfig) *Controller {
 		config: config,
 		queue:  NewQueue(1 * time.Second),
 		kinds:  make(map[string]cacheHandler),
+		ready:  make(chan struct{}),
 	}
 
 	out.services = out.createInformer(&v1.Service{}, config.ResyncPeriod,
@@ -282,6 +288,11 @@ func (c *Controller) HasSynced() bool {
 	return true
 }
 
+// Ready implements model.Controller
+func (c *Controller) Ready() <-chan struct{} {
+	return c.ready
+}
+
 // Run all controllers until a signal is received
 func (c *Controller) Run(stop <-chan struct{}) {
 	go c.queue.Run(stop)
@@ -297,6 +308,12 @@ func (c *Controller) Run(stop <-chan struct{}) {
 		go ctl.informer.Run(stop)
 	}
 
+	go func() {
+		if cache.WaitForCacheSync(stop, c.HasSynced) {
+			c.readyOnce.Do(func() { close(c.ready) })
+		}
+	}()
+
 	<-stop
 	glog.V(2).Info("Controller terminated")
 }
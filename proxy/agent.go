@@ -15,6 +15,7 @@
 package proxy
 
 import (
+	"expvar"
 	"reflect"
 	"time"
 
@@ -71,6 +72,13 @@ const (
 	defaultDelay = 1 * time.Hour
 )
 
+// metrics exposes proxy restart statistics via expvar:
+// - starts: number of proxy epochs started
+// - failures: number of proxy epochs that terminated with an error
+// - current_epoch: latest running epoch, or -1 if none is running
+// - live_epochs: number of running epochs
+var metrics = expvar.NewMap("proxy_agent")
+
 // NewAgent creates a new proxy agent for the proxy start-up and clean-up functions.
 func NewAgent(run func(interface{}, int) error, cleanup func(int),
 	maxRetries int, initialInterval time.Duration) Agent {
@@ -169,6 +177,7 @@ func (a *agent) Run(stop <-chan struct{}) {
 		case status := <-a.statusCh:
 			if status.err != nil {
 				glog.V(2).Infof("Epoch %d terminated with an error: %v", status.epoch, status.err)
+				metrics.Add("failures", 1)
 			} else {
 				glog.V(2).Infof("Epoch %d exited normally", status.epoch)
 			}
@@ -179,6 +188,7 @@ func (a *agent) Run(stop <-chan struct{}) {
 			// delete epoch record and update current config
 			delete(a.epochs, status.epoch)
 			a.currentConfig = a.epochs[a.latestEpoch()]
+			a.updateEpochMetrics()
 
 			// schedule a retry for a transient error
 			if status.err != nil && !reflect.DeepEqual(a.desiredConfig, a.currentConfig) {
@@ -218,9 +228,20 @@ func (a *agent) reconcile() {
 	epoch := a.latestEpoch() + 1
 	a.epochs[epoch] = a.desiredConfig
 	a.currentConfig = a.desiredConfig
+	metrics.Add("starts", 1)
+	a.updateEpochMetrics()
 	go a.waitForExit(a.desiredConfig, epoch)
 }
 
+func (a *agent) updateEpochMetrics() {
+	current := new(expvar.Int)
+	current.Set(int64(a.latestEpoch()))
+	metrics.Set("current_epoch", current)
+	live := new(expvar.Int)
+	live.Set(int64(len(a.epochs)))
+	metrics.Set("live_epochs", live)
+}
+
 // waitForExit runs the start-up command and waits for it to finish
 func (a *agent) waitForExit(config interface{}, epoch int) {
 	err := a.run(config, epoch)
@@ -16,6 +16,7 @@ package proxy
 
 import (
 	"errors"
+	"expvar"
 	"testing"
 	"time"
 )
@@ -250,3 +251,42 @@ func TestRecovery(t *testing.T) {
 	<-time.After(100 * time.Millisecond)
 	close(stop)
 }
+
+func TestNewAgentRetry(t *testing.T) {
+	a := NewAgent(func(interface{}, int) error { return nil }, func(int) {}, 5, 2*time.Second).(*agent)
+	if a.retry.maxRetries != 5 || a.retry.budget != 5 {
+		t.Errorf("NewAgent => got max retries %d and budget %d, want 5", a.retry.maxRetries, a.retry.budget)
+	}
+	if a.retry.initialInterval != 2*time.Second {
+		t.Errorf("NewAgent => got initial interval %v, want 2s", a.retry.initialInterval)
+	}
+}
+
+func TestAgentMetrics(t *testing.T) {
+	counter := func(name string) int64 {
+		if v, ok := metrics.Get(name).(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	starts, failures := counter("starts"), counter("failures")
+
+	stop := make(chan struct{})
+	start := func(config interface{}, epoch int) error {
+		return errors.New("failed to start")
+	}
+	cleanup := func(epoch int) {
+		close(stop)
+	}
+	a := NewAgent(start, cleanup, 1, time.Hour)
+	go a.Run(stop)
+	a.ScheduleConfigUpdate("config")
+	<-stop
+
+	if got := counter("starts"); got != starts+1 {
+		t.Errorf("starts => got %d, want %d", got, starts+1)
+	}
+	if got := counter("failures"); got != failures+1 {
+		t.Errorf("failures => got %d, want %d", got, failures+1)
+	}
+}
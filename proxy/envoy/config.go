@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang/glog"
@@ -116,6 +117,71 @@ func Generate(context *ProxyContext) *Config {
 	}
 }
 
+// GenerateStatic produces Envoy sidecar proxy configuration with the current
+// discovery output baked in: HTTP routes are inlined into the listeners and
+// outbound clusters list their hosts statically, so that the proxy does not
+// need a discovery server. The result is a snapshot and must be regenerated
+// on any change to the service registry or the rules.
+func GenerateStatic(context *ProxyContext) *Config {
+	config := Generate(context)
+
+	instances := context.Discovery.HostInstances(map[string]bool{context.IPAddress: true})
+	services := context.Discovery.Services()
+	httpConfigs := buildOutboundHTTPRoutes(instances, services, context)
+
+	// inline route configs in place of RDS
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters {
+			http, ok := filter.Config.(*HTTPFilterConfig)
+			if !ok || http.RDS == nil {
+				continue
+			}
+			port, err := strconv.Atoi(http.RDS.RouteConfigName)
+			if err != nil {
+				glog.Warningf("Unexpected route config name %q: %v", http.RDS.RouteConfigName, err)
+				continue
+			}
+			http.RouteConfig = httpConfigs[port]
+			http.RDS = nil
+		}
+	}
+
+	// replace the RDS cluster with the clusters referenced by the HTTP routes
+	clusters := make(Clusters, 0, len(config.ClusterManager.Clusters))
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name != RDSName {
+			clusters = append(clusters, cluster)
+		}
+	}
+	httpClusters := httpConfigs.clusters().normalize()
+	for _, cluster := range httpClusters {
+		insertDestinationPolicy(context.Config, cluster)
+	}
+	clusters = append(clusters, httpClusters...).normalize()
+
+	// resolve service discovery clusters to static hosts
+	for _, cluster := range clusters {
+		if cluster.Type != "sds" {
+			continue
+		}
+		hosts := make([]Host, 0)
+		for _, instance := range context.Discovery.Instances(cluster.hostname,
+			[]string{cluster.port.Name}, model.TagsList{cluster.tags}) {
+			hosts = append(hosts, Host{
+				URL: fmt.Sprintf("tcp://%s:%d", instance.Endpoint.Address, instance.Endpoint.Port),
+			})
+		}
+		cluster.Type = "static"
+		cluster.ServiceName = ""
+		cluster.Hosts = hosts
+	}
+
+	config.ClusterManager.Clusters = clusters
+	config.ClusterManager.SDS = nil
+	config.ClusterManager.CDS = nil
+	return config
+}
+
 // buildListeners produces a list of listeners and referenced clusters
 // (due to lack of RDS support for TCP proxy filter, all referenced clusters in TCP routes
 // must be present)
@@ -212,6 +278,7 @@ func buildOutboundListeners(instances []*model.ServiceInstance, services []*mode
 	context *ProxyContext) (Listeners, Clusters) {
 	httpOutbound := buildOutboundHTTPRoutes(instances, services, context)
 	listeners, clusters := buildOutboundTCPListeners(context.MeshConfig, services)
+	clusters.setExternalHosts(context.Discovery)
 	for port, routeConfig := range httpOutbound {
 		listeners = append(listeners, buildHTTPListener(context.MeshConfig, routeConfig, WildcardAddress, port, true, false))
 	}
@@ -283,15 +350,19 @@ func buildOutboundHTTPRoutes(instances []*model.ServiceInstance, services []*mod
 		}
 
 		clusters.setTimeout(context.MeshConfig.ConnectTimeout)
+		clusters.setExternalHosts(context.Discovery)
 
 		// apply SSL context to outbound clusters for authentication policy
 		switch context.MeshConfig.AuthPolicy {
 		case proxyconfig.ProxyMeshConfig_NONE:
 		case proxyconfig.ProxyMeshConfig_MUTUAL_TLS:
 			serviceAccounts := context.Discovery.GetIstioServiceAccounts(service.Hostname, service.Ports.GetNames())
-			sslContext := buildClusterSSLContext(context.MeshConfig.AuthCertsPath, serviceAccounts)
 			for _, cluster := range clusters {
-				cluster.SSLContext = sslContext
+				// external services do not present Istio certificates
+				if cluster.external {
+					continue
+				}
+				cluster.SSLContext = buildClusterSSLContext(context.MeshConfig.AuthCertsPath, serviceAccounts, cluster)
 			}
 		default:
 			glog.Warningf("Unknown auth policy: %v", context.MeshConfig.AuthPolicy)
@@ -349,6 +420,12 @@ func buildInboundListeners(instances []*model.ServiceInstance,
 	// inbound connections/requests are redirected to the endpoint address but appear to be sent
 	// to the service address
 	// assumes that endpoint addresses/ports are unique in the instance set
+	if err := model.ValidateInstancePorts(instances); err != nil {
+		glog.Warningf("Inbound listeners may be inconsistent: %v", err)
+	}
+	if err := model.ValidateProxyAdminPort(mesh, instances); err != nil {
+		glog.Warningf("Proxy admin interface may be unreachable: %v", err)
+	}
 	for _, instance := range instances {
 		service := instance.Service
 		endpoint := instance.Endpoint
@@ -223,6 +223,8 @@ const (
 	envoyV1ConfigAuth = "testdata/envoy-v1-auth.json"
 	envoyFaultConfig  = "testdata/envoy-fault.json"
 	cbPolicy          = "testdata/cb-policy.yaml.golden"
+	cbPolicyV0        = "testdata/cb-policy-v0.yaml.golden"
+	cbPolicyV1        = "testdata/cb-policy-v1.yaml.golden"
 	timeoutRouteRule  = "testdata/timeout-route-rule.yaml.golden"
 	weightedRouteRule = "testdata/weighted-route.yaml.golden"
 	faultRouteRule    = "testdata/fault-route.yaml.golden"
@@ -273,6 +275,18 @@ func addCircuitBreaker(r *model.IstioRegistry, t *testing.T) {
 	}
 }
 
+func addSubsetCircuitBreakers(r *model.IstioRegistry, t *testing.T) {
+	for name, file := range map[string]string{"circuit-breaker-v0": cbPolicyV0, "circuit-breaker-v1": cbPolicyV1} {
+		msg, err := configObjectFromYAML(model.DestinationPolicy, file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = r.Post(model.Key{Kind: model.DestinationPolicy, Name: name}, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
 func addTimeout(r *model.IstioRegistry, t *testing.T) {
 	msg, err := configObjectFromYAML(model.RouteRule, timeoutRouteRule)
 	if err != nil {
@@ -356,3 +370,80 @@ func TestMockConfigFault(t *testing.T) {
 	testConfig(r, &mesh, mock.HostInstanceV0, envoyFaultConfig, t)
 	testConfig(r, &mesh, mock.HostInstanceV1, envoyV1Config, t)
 }
+
+func TestMockConfigStatic(t *testing.T) {
+	r := mock.MakeRegistry()
+	mesh := DefaultMeshConfig
+	mesh.MixerAddress = "mixer:9091"
+	addWeightedRoute(r, t)
+	config := GenerateStatic(&ProxyContext{
+		Discovery:  mock.Discovery,
+		Config:     r,
+		MeshConfig: &mesh,
+		IPAddress:  mock.HostInstanceV0,
+	})
+
+	if config.ClusterManager.SDS != nil || config.ClusterManager.CDS != nil {
+		t.Error("static config should not use SDS or CDS")
+	}
+
+	routes := 0
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters {
+			if http, ok := filter.Config.(*HTTPFilterConfig); ok {
+				if http.RDS != nil {
+					t.Errorf("static config should not use RDS for listener %s", listener.Address)
+				}
+				if http.RouteConfig == nil || len(http.RouteConfig.VirtualHosts) == 0 {
+					t.Errorf("missing inline routes for listener %s", listener.Address)
+				}
+				routes++
+			}
+		}
+	}
+	if routes == 0 {
+		t.Error("static config has no HTTP listeners")
+	}
+
+	clusters := make(map[string]*Cluster)
+	for _, cluster := range config.ClusterManager.Clusters {
+		if cluster.Name == RDSName {
+			t.Error("static config should not include the RDS cluster")
+		}
+		if cluster.Type == "sds" {
+			t.Errorf("cluster %s should be static", cluster.Name)
+		}
+		clusters[cluster.Name] = cluster
+	}
+
+	// every cluster referenced by the inline routes must be defined with hosts
+	for _, listener := range config.Listeners {
+		for _, filter := range listener.Filters {
+			if http, ok := filter.Config.(*HTTPFilterConfig); ok && http.RouteConfig != nil {
+				for _, ref := range http.RouteConfig.clusters() {
+					cluster, ok := clusters[ref.Name]
+					if !ok {
+						t.Errorf("missing inline cluster %s", ref.Name)
+					} else if len(cluster.Hosts) == 0 {
+						t.Errorf("missing hosts for cluster %s", ref.Name)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestMockConfigAdmin(t *testing.T) {
+	mesh := DefaultMeshConfig
+	mesh.ProxyAdminPort = 15500
+	config := Generate(&ProxyContext{
+		Discovery:  mock.Discovery,
+		Config:     mock.MakeRegistry(),
+		MeshConfig: &mesh,
+		IPAddress:  mock.HostInstanceV0,
+	})
+	want := Admin{AccessLogPath: DefaultAccessLog, Address: "tcp://0.0.0.0:15500"}
+	if config.Admin != want {
+		t.Errorf("Generate() admin => got %+v, want %+v", config.Admin, want)
+	}
+}
@@ -15,13 +15,23 @@
 package envoy
 
 import (
+	"compress/gzip"
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/pprof"
+	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/golang/glog"
@@ -38,16 +48,40 @@ type DiscoveryService struct {
 	config     *model.IstioRegistry
 	mesh       *proxyconfig.ProxyMeshConfig
 	server     *http.Server
+	listener   net.Listener
+	sdsFormat  string
+	profiling  bool
+	audit      func(AuditEvent)
+	scoped     bool
+	proxies    *proxyTracker
+	drains     *drainMarks
+	// configGeneration counts the service, instance, and config changes
+	configGeneration uint64 // atomic
+	// registryTimeout bounds the computation of a response from the service registry
+	registryTimeout time.Duration
+	// registryCalls holds a token for each running registry computation
+	registryCalls chan struct{}
+	// compressionThreshold is the smallest response compressed for clients
+	// accepting gzip, or zero if compression is disabled
+	compressionThreshold int
 
 	// TODO Profile and optimize cache eviction policy to avoid
 	// flushing the entire cache when any route, service, or endpoint
-	// changes. An explicit cache expiration policy should be
-	// considered with this change to avoid memory exhaustion as the
-	// entire cache will no longer be periodically flushed and stale
-	// entries can linger in the cache indefinitely.
+	// changes. With a cache TTL, entries also expire on their own and
+	// are purged in the background.
 	sdsCache *discoveryCache
 	cdsCache *discoveryCache
 	rdsCache *discoveryCache
+	ldsCache *discoveryCache
+
+	// stop ends the cache janitor
+	stop     chan struct{}
+	stopOnce sync.Once
+
+	// ruleFindings holds the route rule findings last logged per namespace,
+	// so that a finding is logged once rather than on every event
+	ruleFindings   map[string]map[string]bool
+	ruleFindingsMu sync.Mutex
 }
 
 type discoveryCacheStatEntry struct {
@@ -55,31 +89,99 @@ type discoveryCacheStatEntry struct {
 	Miss uint64 `json:"miss"`
 }
 
+// discoveryCacheSize counts the cache entries holding a response (warm) and all
+// entries, including those cleared since the last request
+type discoveryCacheSize struct {
+	Warm  int `json:"warm"`
+	Total int `json:"total"`
+}
+
 type discoveryCacheStats struct {
 	Stats map[string]*discoveryCacheStatEntry `json:"cache_stats"`
+	Sizes map[string]*discoveryCacheSize      `json:"cache_sizes"`
 }
 
 type discoveryCacheEntry struct {
-	data []byte
-	hit  uint64 // atomic
-	miss uint64 // atmoic
+	data   []byte
+	etag   string
+	stored time.Time
+	hit    uint64 // atomic
+	miss   uint64 // atmoic
+	// element is the position of the entry key in the cache recency list
+	element *list.Element
 }
 
 type discoveryCache struct {
 	disabled bool
 	mu       sync.RWMutex
 	cache    map[string]*discoveryCacheEntry
+	// generation is incremented on every clear, so that responses computed
+	// before a clear are not cached after it
+	generation uint64
+	// ttl is how long a response is cached, or forever if zero
+	ttl time.Duration
+	now func() time.Time
+	// maxEntries bounds the number of entries, or unbounded if zero.
+	// The least recently used entry is evicted to make room for a new one,
+	// dropping its hit and miss stats.
+	maxEntries int
+	// recency lists the entry keys from the most to the least recently used.
+	// It is guarded by recencyMu, since hits reorder it under the read lock.
+	recency   *list.List
+	recencyMu sync.Mutex
 }
 
-func newDiscoveryCache(enabled bool) *discoveryCache {
+func newDiscoveryCache(enabled bool, ttl time.Duration, maxEntries int) *discoveryCache {
 	return &discoveryCache{
-		disabled: !enabled,
-		cache:    make(map[string]*discoveryCacheEntry),
+		disabled:   !enabled,
+		cache:      make(map[string]*discoveryCacheEntry),
+		ttl:        ttl,
+		now:        time.Now,
+		maxEntries: maxEntries,
+		recency:    list.New(),
+	}
+}
+
+// touch marks the entry as the most recently used, and is safe under the read lock
+func (c *discoveryCache) touch(entry *discoveryCacheEntry) {
+	c.recencyMu.Lock()
+	c.recency.MoveToFront(entry.element)
+	c.recencyMu.Unlock()
+}
+
+// add inserts a new entry for the key, and must be called under the write lock
+func (c *discoveryCache) add(key string) *discoveryCacheEntry {
+	entry := &discoveryCacheEntry{element: c.recency.PushFront(key)}
+	c.cache[key] = entry
+	return entry
+}
+
+// remove deletes the entry for the key, and must be called under the write lock
+func (c *discoveryCache) remove(key string) {
+	if entry, ok := c.cache[key]; ok {
+		c.recency.Remove(entry.element)
+		delete(c.cache, key)
 	}
 }
-func (c *discoveryCache) cachedDiscoveryResponse(key string) ([]byte, bool) {
+
+// evict removes the least recently used entries until there is room for one
+// more entry, and must be called under the write lock
+func (c *discoveryCache) evict() {
+	for c.maxEntries > 0 && len(c.cache) >= c.maxEntries {
+		c.remove(c.recency.Back().Value.(string))
+	}
+}
+
+func (c *discoveryCache) expired(entry *discoveryCacheEntry) bool {
+	return c.ttl > 0 && c.now().Sub(entry.stored) > c.ttl
+}
+
+// cachedDiscoveryResponse returns the cached response for the key and its
+// entity tag, if any, and the cache generation to pass to
+// updateCachedDiscoveryResponse on a miss
+func (c *discoveryCache) cachedDiscoveryResponse(key string) ([]byte, string, uint64, bool) {
 	if c.disabled {
-		return nil, false
+		return nil, "", 0, false
 	}
 
 	c.mu.RLock()
@@ -87,18 +189,23 @@ func (c *discoveryCache) cachedDiscoveryResponse(key string) ([]byte, bool) {
 
 	// Miss - entry.miss is updated in updateCachedDiscoveryResponse
 	entry, ok := c.cache[key]
-	if !ok || entry.data == nil {
-		return nil, false
+	if !ok || entry.data == nil || c.expired(entry) {
+		return nil, "", c.generation, false
 	}
 
 	// Hit
 	atomic.AddUint64(&entry.hit, 1)
-	return entry.data, true
+	c.touch(entry)
+	return entry.data, entry.etag, c.generation, true
 }
 
-func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte) {
+// updateCachedDiscoveryResponse records a miss for the key and caches the
+// response unless the cache was cleared since the generation was read.
+// It returns the entity tag of the response.
+func (c *discoveryCache) updateCachedDiscoveryResponse(key string, generation uint64, data []byte) string {
+	etag := responseETag(data)
 	if c.disabled {
-		return
+		return etag
 	}
 
 	c.mu.Lock()
@@ -106,23 +213,54 @@ func (c *discoveryCache) updateCachedDiscoveryResponse(key string, data []byte)
 
 	entry, ok := c.cache[key]
 	if !ok {
-		entry = &discoveryCacheEntry{}
-		c.cache[key] = entry
-	} else if entry.data != nil {
+		c.evict()
+		entry = c.add(key)
+	}
+	atomic.AddUint64(&entry.miss, 1)
+	c.touch(entry)
+	if generation != c.generation {
+		// the response may reflect state from before the clear
+		return etag
+	}
+	if entry.data != nil && !c.expired(entry) {
 		glog.Warningf("Overriding cached data for entry %v", key)
 	}
 	entry.data = data
-	atomic.AddUint64(&entry.miss, 1)
+	entry.etag = etag
+	entry.stored = c.now()
+	return etag
+}
+
+// responseETag returns the strong entity tag of a discovery response
+func responseETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
 }
 
 func (c *discoveryCache) clear() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	c.generation++
 	for _, v := range c.cache {
 		v.data = nil
 	}
 }
 
+// purge removes the expired entries, including their stats, whether they
+// still hold a response or were cleared
+func (c *discoveryCache) purge() {
+	if c.ttl <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range c.cache {
+		if c.expired(v) {
+			c.remove(k)
+		}
+	}
+}
+
 func (c *discoveryCache) resetStats() {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -145,6 +283,18 @@ func (c *discoveryCache) stats() map[string]*discoveryCacheStatEntry {
 	return stats
 }
 
+func (c *discoveryCache) size() *discoveryCacheSize {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := &discoveryCacheSize{Total: len(c.cache)}
+	for _, v := range c.cache {
+		if v.data != nil {
+			out.Warm++
+		}
+	}
+	return out
+}
+
 type hosts struct {
 	Hosts []*host `json:"hosts"`
 }
@@ -157,14 +307,251 @@ type host struct {
 	Weight int `json:"load_balancing_weight,omitempty"`
 }
 
+// localityLbEndpoints is the Envoy v2 SDS response, grouping endpoints
+// of a cluster by locality
+type localityLbEndpoints struct {
+	ClusterName string                `json:"cluster_name"`
+	Endpoints   []*localityLbEndpoint `json:"endpoints"`
+}
+
+type localityLbEndpoint struct {
+	Locality    locality      `json:"locality"`
+	LbEndpoints []*lbEndpoint `json:"lb_endpoints"`
+
+	// Weight is the number of endpoints in the locality
+	Weight int `json:"load_balancing_weight"`
+}
+
+type locality struct {
+	Region string `json:"region,omitempty"`
+	Zone   string `json:"zone,omitempty"`
+}
+
+type lbEndpoint struct {
+	Endpoint     endpoint  `json:"endpoint"`
+	HealthStatus string    `json:"health_status,omitempty"`
+	Metadata     *metadata `json:"metadata,omitempty"`
+	Weight       int       `json:"load_balancing_weight"`
+}
+
+// metadata carries the instance tags under the Envoy load balancer
+// namespace for subset load balancing
+type metadata struct {
+	FilterMetadata map[string]model.Tags `json:"filter_metadata"`
+}
+
+// LbMetadataNamespace is the Envoy metadata namespace used for subset load balancing
+const LbMetadataNamespace = "envoy.lb"
+
+// TLSMetadataNamespace is the endpoint metadata namespace holding the TLS
+// settings of the endpoint, read by the TLS context of the cluster
+const TLSMetadataNamespace = "istio.tls"
+
+// SNIMetadataKey is the TLS metadata key for the endpoint server name
+const SNIMetadataKey = "sni"
+
+// HealthStatusDraining is the v2 SDS health status of draining endpoints
+const HealthStatusDraining = "DRAINING"
+
+type endpoint struct {
+	Address address `json:"address"`
+}
+
+type address struct {
+	SocketAddress socketAddress `json:"socket_address"`
+}
+
+type socketAddress struct {
+	Address   string `json:"address"`
+	PortValue int    `json:"port_value"`
+}
+
+// SDS response formats
+const (
+	// SDSFormatV1 is the Envoy v1 hosts format
+	SDSFormatV1 = "v1"
+	// SDSFormatV2 is the Envoy v2 locality endpoints format
+	SDSFormatV2 = "v2"
+
+	// MIMEEnvoyV2 selects the v2 SDS format via the Accept header
+	MIMEEnvoyV2 = "application/vnd.envoy.v2+json"
+)
+
+// Instance tags used to derive the endpoint locality
+const (
+	RegionTag = "region"
+	ZoneTag   = "zone"
+)
+
+// WeightTag is the instance tag holding the v1 SDS load balancing weight of
+// the endpoint, an integer in the range [1, 100]
+const WeightTag = "istio.io/weight"
+
 // Request parameters for discovery services
 const (
 	ServiceKey      = "service-key"
 	ServiceCluster  = "service-cluster"
 	ServiceNode     = "service-node"
 	RouteConfigName = "route-config-name"
+
+	// Raw is a query parameter to skip destination policies in CDS, enabled with profiling
+	Raw = "raw"
+
+	// DrainDuration is a query parameter for how long a proxy node is drained
+	DrainDuration = "duration"
+)
+
+// ProxyExpiry is how long a proxy is listed after its last CDS, RDS, or LDS request
+const ProxyExpiry = 10 * time.Minute
+
+// proxyExpiryRecords is how many requests are recorded between scans for
+// expired proxies, so that recording a request does not scan every proxy
+const proxyExpiryRecords = 256
+
+// proxyEntry describes a proxy that recently requested clusters or routes. The
+// generation is the config generation of the last response served to the proxy,
+// and the proxy is lagging if changes were made since.
+type proxyEntry struct {
+	ServiceCluster string    `json:"service_cluster"`
+	ServiceNode    string    `json:"service_node"`
+	LastRequest    time.Time `json:"last_request"`
+	Generation     uint64    `json:"generation"`
+	Lagging        bool      `json:"lagging"`
+}
+
+// proxyTracker records recently seen proxies, dropping those not seen within
+// the expiry to bound memory usage
+type proxyTracker struct {
+	expiry  time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	proxies map[string]*proxyEntry
+	// records counts the requests recorded since the last expiry scan
+	records int
+}
+
+func newProxyTracker(expiry time.Duration) *proxyTracker {
+	return &proxyTracker{
+		expiry:  expiry,
+		now:     time.Now,
+		proxies: make(map[string]*proxyEntry),
+	}
+}
+
+func (t *proxyTracker) record(cluster, node string, generation uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if t.records++; t.records >= proxyExpiryRecords {
+		t.expire(now)
+	}
+	t.proxies[cluster+" "+node] = &proxyEntry{
+		ServiceCluster: cluster,
+		ServiceNode:    node,
+		LastRequest:    now,
+		Generation:     generation,
+	}
+}
+
+// list returns the proxies seen within the expiry, sorted by service node, and
+// marks those last served before the current config generation as lagging
+func (t *proxyTracker) list(current uint64) []proxyEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.expire(t.now())
+	out := make([]proxyEntry, 0, len(t.proxies))
+	for _, entry := range t.proxies {
+		proxy := *entry
+		proxy.Lagging = proxy.Generation < current
+		out = append(out, proxy)
+	}
+	sort.Sort(proxiesByNode(out))
+	return out
+}
+
+// expire must be called with the lock held
+func (t *proxyTracker) expire(now time.Time) {
+	t.records = 0
+	for key, entry := range t.proxies {
+		if now.Sub(entry.LastRequest) > t.expiry {
+			delete(t.proxies, key)
+		}
+	}
+}
+
+type proxiesByNode []proxyEntry
+
+func (s proxiesByNode) Len() int {
+	return len(s)
+}
+
+func (s proxiesByNode) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s proxiesByNode) Less(i, j int) bool {
+	if s[i].ServiceNode == s[j].ServiceNode {
+		return s[i].ServiceCluster < s[j].ServiceCluster
+	}
+	return s[i].ServiceNode < s[j].ServiceNode
+}
+
+const (
+	// DefaultDrainDuration is how long a proxy node is drained if no duration is given
+	DefaultDrainDuration = 5 * time.Minute
+
+	// MaxDrainDuration is the longest a proxy node can be drained at once
+	MaxDrainDuration = time.Hour
 )
 
+// drainMarks records the proxy nodes (IP addresses) whose endpoints are
+// draining, until each mark expires
+type drainMarks struct {
+	now   func() time.Time
+	mu    sync.Mutex
+	until map[string]time.Time
+	// timers run the expiry callback of each node, and are reset when a
+	// node is marked again
+	timers map[string]*time.Timer
+}
+
+func newDrainMarks() *drainMarks {
+	return &drainMarks{
+		now:    time.Now,
+		until:  make(map[string]time.Time),
+		timers: make(map[string]*time.Timer),
+	}
+}
+
+// mark drains a node for a duration and calls expire once the mark expires.
+// Marking a draining node again extends the mark instead of adding a timer.
+func (d *drainMarks) mark(ip string, duration time.Duration, expire func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.until[ip] = d.now().Add(duration)
+	if timer, ok := d.timers[ip]; ok {
+		timer.Reset(duration)
+		return
+	}
+	d.timers[ip] = time.AfterFunc(duration, expire)
+}
+
+// draining returns the set of draining addresses, dropping expired marks
+func (d *drainMarks) draining() map[string]bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := d.now()
+	out := make(map[string]bool)
+	for ip, until := range d.until {
+		if now.Before(until) {
+			out[ip] = true
+		} else {
+			delete(d.until, ip)
+		}
+	}
+	return out
+}
+
 // DiscoveryServiceOptions contains options for create a new discovery
 // service instance.
 type DiscoveryServiceOptions struct {
@@ -175,18 +562,96 @@ type DiscoveryServiceOptions struct {
 	Port            int
 	EnableProfiling bool
 	EnableCaching   bool
+
+	// SDSFormat is the default SDS response format, SDSFormatV1 if empty
+	SDSFormat string
+
+	// UnixSocket is an optional Unix domain socket path to serve discovery on,
+	// in addition to the TCP port. TCP is disabled if Port is zero.
+	UnixSocket string
+
+	// Audit is an optional callback invoked for every registry change that
+	// triggers discovery recomputation
+	Audit func(AuditEvent)
+
+	// ScopeServices limits CDS and RDS for a proxy node to the services that
+	// the route rules applicable to the node refer to. All services are
+	// included if disabled.
+	ScopeServices bool
+
+	// CacheTTL is how long discovery responses are cached. Expired responses
+	// are recomputed on the next request and purged in the background. The
+	// cache is only flushed on registry changes if zero.
+	CacheTTL time.Duration
+
+	// CacheMaxEntries bounds the number of cached responses of each discovery
+	// service type, evicting the least recently used ones. Unbounded if zero.
+	CacheMaxEntries int
+
+	// RegistryTimeout bounds how long a request waits on the computation of
+	// a response from the service registry, before failing with
+	// http.StatusServiceUnavailable. Unbounded if zero. Timed out
+	// computations keep running, and at most MaxRegistryCalls run at once.
+	RegistryTimeout time.Duration
+
+	// EnableCompression gzips responses larger than CompressionThreshold
+	// bytes for clients accepting gzip. The threshold defaults to
+	// DefaultCompressionThreshold if zero.
+	EnableCompression    bool
+	CompressionThreshold int
+}
+
+// DefaultCompressionThreshold is the default size in bytes above which
+// discovery responses are compressed
+const DefaultCompressionThreshold = 1024
+
+// AuditEvent describes a registry change observed by the discovery service
+type AuditEvent struct {
+	// Event is the type of change
+	Event model.Event
+	// Kind is "service", "instance", or the configuration kind
+	Kind string
+	// Key identifies the changed service, instance, or configuration object
+	Key string
+	// Time is when the change was observed
+	Time time.Time
 }
 
 // NewDiscoveryService creates an Envoy discovery service on a given port
 func NewDiscoveryService(o DiscoveryServiceOptions) (*DiscoveryService, error) {
+	if err := model.ValidateProxyMeshConfig(o.Mesh); err != nil {
+		return nil, err
+	}
 	out := &DiscoveryService{
-		services:   o.Services,
-		controller: o.Controller,
-		config:     o.Config,
-		mesh:       o.Mesh,
-		sdsCache:   newDiscoveryCache(o.EnableCaching),
-		cdsCache:   newDiscoveryCache(o.EnableCaching),
-		rdsCache:   newDiscoveryCache(o.EnableCaching),
+		services:        o.Services,
+		controller:      o.Controller,
+		config:          o.Config,
+		mesh:            o.Mesh,
+		sdsCache:        newDiscoveryCache(o.EnableCaching, o.CacheTTL, o.CacheMaxEntries),
+		cdsCache:        newDiscoveryCache(o.EnableCaching, o.CacheTTL, o.CacheMaxEntries),
+		rdsCache:        newDiscoveryCache(o.EnableCaching, o.CacheTTL, o.CacheMaxEntries),
+		ldsCache:        newDiscoveryCache(o.EnableCaching, o.CacheTTL, o.CacheMaxEntries),
+		sdsFormat:       o.SDSFormat,
+		profiling:       o.EnableProfiling,
+		audit:           o.Audit,
+		registryTimeout: o.RegistryTimeout,
+		registryCalls:   make(chan struct{}, MaxRegistryCalls),
+		scoped:          o.ScopeServices,
+		proxies:         newProxyTracker(ProxyExpiry),
+		drains:          newDrainMarks(),
+		stop:            make(chan struct{}),
+	}
+	if out.sdsFormat == "" {
+		out.sdsFormat = SDSFormatV1
+	}
+	if o.EnableCompression {
+		out.compressionThreshold = o.CompressionThreshold
+		if out.compressionThreshold <= 0 {
+			out.compressionThreshold = DefaultCompressionThreshold
+		}
+	}
+	if out.sdsFormat != SDSFormatV1 && out.sdsFormat != SDSFormatV2 {
+		return nil, fmt.Errorf("unknown SDS format %q", out.sdsFormat)
 	}
 	container := restful.NewContainer()
 	if o.EnableProfiling {
@@ -198,28 +663,113 @@ func NewDiscoveryService(o DiscoveryServiceOptions) (*DiscoveryService, error) {
 	}
 	out.Register(container)
 	out.server = &http.Server{Addr: ":" + strconv.Itoa(o.Port), Handler: container}
+	if o.UnixSocket != "" {
+		if o.Port == 0 {
+			out.server.Addr = ""
+		}
+		// remove a stale socket left behind by a previous instance
+		if err := os.Remove(o.UnixSocket); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		listener, err := net.Listen("unix", o.UnixSocket)
+		if err != nil {
+			return nil, err
+		}
+		out.listener = listener
+	}
 
 	// Flush cached discovery responses whenever services, service
 	// instances, or routing configuration changes.
-	serviceHandler := func(s *model.Service, e model.Event) { out.clearCache() }
+	serviceHandler := func(s *model.Service, e model.Event) {
+		out.auditEvent(e, "service", s.Hostname)
+		out.clearCache()
+	}
 	if err := o.Controller.AppendServiceHandler(serviceHandler); err != nil {
 		return nil, err
 	}
-	instanceHandler := func(s *model.ServiceInstance, e model.Event) { out.clearCache() }
+	instanceHandler := func(s *model.ServiceInstance, e model.Event) {
+		// instance events may not carry the service, e.g. on delete
+		key := fmt.Sprintf("%s:%d", s.Endpoint.Address, s.Endpoint.Port)
+		if s.Service != nil {
+			key = s.Service.Key(s.Endpoint.ServicePort, s.Tags) + " " + key
+		}
+		out.auditEvent(e, "instance", key)
+		out.clearCache()
+		if e == model.EventDelete && s.Service != nil && !model.CheckServiceInstances(s.Service, out.services) {
+			glog.Warningf("Service %q has no instances: traffic to it is dropped", s.Service.Hostname)
+		}
+	}
 	if err := o.Controller.AppendInstanceHandler(instanceHandler); err != nil {
 		return nil, err
 	}
-	configHandler := func(k model.Key, m proto.Message, e model.Event) { out.clearCache() }
-	if err := o.Controller.AppendConfigHandler(model.RouteRule, configHandler); err != nil {
+	configHandler := func(k model.Key, m proto.Message, e model.Event) {
+		out.auditEvent(e, k.Kind, k.String())
+		out.clearCache()
+	}
+	routeRuleHandler := func(k model.Key, m proto.Message, e model.Event) {
+		configHandler(k, m, e)
+		for _, finding := range out.newRuleFindings(k.Namespace, routeRuleFindings(out.config.RouteRules(k.Namespace))) {
+			glog.Warning(finding)
+		}
+		if rule, ok := m.(*proxyconfig.RouteRule); ok && e != model.EventDelete {
+			for _, weighted := range endpointWeightedRoutes(rule, out.services) {
+				glog.Warning(weighted)
+			}
+		}
+	}
+	if err := o.Controller.AppendConfigHandler(model.RouteRule, routeRuleHandler); err != nil {
 		return nil, err
 	}
-	if err := o.Controller.AppendConfigHandler(model.DestinationPolicy, configHandler); err != nil {
+	destinationPolicyHandler := func(k model.Key, m proto.Message, e model.Event) {
+		configHandler(k, m, e)
+		if policy, ok := m.(*proxyconfig.DestinationPolicy); ok && e != model.EventDelete &&
+			!model.CheckDestinationPolicyEndpoints(policy, out.services) {
+			glog.Warningf("Destination policy for %q has no endpoints with tags %v",
+				policy.Destination, model.Tags(policy.Tags))
+		}
+	}
+	if err := o.Controller.AppendConfigHandler(model.DestinationPolicy, destinationPolicyHandler); err != nil {
 		return nil, err
 	}
 
+	if o.EnableCaching && o.CacheTTL > 0 {
+		go out.purgeCaches(o.CacheTTL)
+	}
+
 	return out, nil
 }
 
+// routeRuleFindings lists the shadowed rules, stacked faults, and loops among
+// the route rules
+func routeRuleFindings(rules map[model.Key]*proxyconfig.RouteRule) []string {
+	out := append(model.RouteRuleShadows(rules), model.StackedFaultRules(rules)...)
+	if err := model.ValidateRouteRuleCycles(rules); err != nil {
+		out = append(out, fmt.Sprintf("Route rule loop: %v", err))
+	}
+	return out
+}
+
+// newRuleFindings records the current route rule findings of a namespace and
+// returns those that were not reported for it before
+func (ds *DiscoveryService) newRuleFindings(namespace string, findings []string) []string {
+	ds.ruleFindingsMu.Lock()
+	defer ds.ruleFindingsMu.Unlock()
+	if ds.ruleFindings == nil {
+		ds.ruleFindings = make(map[string]map[string]bool)
+	}
+	reported := ds.ruleFindings[namespace]
+	current := make(map[string]bool, len(findings))
+	out := make([]string, 0)
+	for _, finding := range findings {
+		if !reported[finding] && !current[finding] {
+			out = append(out, finding)
+		}
+		current[finding] = true
+	}
+	ds.ruleFindings[namespace] = current
+	return out
+}
+
 // Register adds routes a web service container
 func (ds *DiscoveryService) Register(container *restful.Container) {
 	ws := &restful.WebService{}
@@ -230,7 +780,14 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 		To(ds.ListEndpoints).
 		Doc("SDS registration").
 		Param(ws.PathParameter(ServiceKey, "tuple of service name and tag name").DataType("string")).
-		Produces(restful.MIME_JSON))
+		Produces(restful.MIME_JSON, MIMEEnvoyV2))
+
+	ws.Route(ws.
+		POST("/v1/registration").
+		To(ds.ListEndpointsByBody).
+		Doc("SDS registration for long service keys").
+		Consumes("text/plain").
+		Produces(restful.MIME_JSON, MIMEEnvoyV2))
 
 	ws.Route(ws.
 		GET(fmt.Sprintf("/v1/clusters/{%s}/{%s}", ServiceCluster, ServiceNode)).
@@ -238,6 +795,7 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 		Doc("CDS registration").
 		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
 		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")).
+		Param(ws.QueryParameter(Raw, "skip destination policies (requires profiling)").DataType("boolean")).
 		Produces(restful.MIME_JSON))
 
 	ws.Route(ws.
@@ -249,6 +807,27 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")).
 		Produces(restful.MIME_JSON))
 
+	ws.Route(ws.
+		GET(fmt.Sprintf("/v1/listeners/{%s}/{%s}", ServiceCluster, ServiceNode)).
+		To(ds.ListListeners).
+		Doc("LDS registration").
+		Param(ws.PathParameter(ServiceCluster, "client proxy service cluster").DataType("string")).
+		Param(ws.PathParameter(ServiceNode, "client proxy service node").DataType("string")).
+		Produces(restful.MIME_JSON))
+
+	ws.Route(ws.
+		POST(fmt.Sprintf("/v1/drain/{%s}", ServiceNode)).
+		To(ds.DrainNode).
+		Doc("Drain the endpoints of a proxy node in SDS responses (requires profiling)").
+		Param(ws.PathParameter(ServiceNode, "proxy node IP address").DataType("string")).
+		Param(ws.QueryParameter(DrainDuration, "how long to drain the node, e.g. 30s").DataType("string")))
+
+	ws.Route(ws.
+		GET("/v1/proxies").
+		To(ds.ListProxies).
+		Doc("List proxies recently served by CDS, RDS, or LDS").
+		Writes([]proxyEntry{}))
+
 	ws.Route(ws.
 		GET("/cache_stats").
 		To(ds.GetCacheStats).
@@ -265,12 +844,57 @@ func (ds *DiscoveryService) Register(container *restful.Container) {
 
 // Run starts the server and blocks
 func (ds *DiscoveryService) Run() {
+	if ds.listener != nil {
+		glog.Infof("Starting discovery service at %v", ds.listener.Addr())
+		if ds.server.Addr == "" {
+			ds.serveUnix()
+			return
+		}
+		go ds.serveUnix()
+	}
 	glog.Infof("Starting discovery service at %v", ds.server.Addr)
 	if err := ds.server.ListenAndServe(); err != nil {
 		glog.Warning(err)
 	}
 }
 
+func (ds *DiscoveryService) serveUnix() {
+	if err := ds.server.Serve(ds.listener); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// purgeCaches periodically removes expired cache entries until stopped
+func (ds *DiscoveryService) purgeCaches(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ds.sdsCache.purge()
+			ds.cdsCache.purge()
+			ds.rdsCache.purge()
+			ds.ldsCache.purge()
+		case <-ds.stop:
+			return
+		}
+	}
+}
+
+// Stop ends the background cache eviction
+func (ds *DiscoveryService) Stop() {
+	ds.stopOnce.Do(func() { close(ds.stop) })
+}
+
+// Close stops serving on the Unix domain socket and removes the socket file
+func (ds *DiscoveryService) Close() error {
+	if ds.listener == nil {
+		return nil
+	}
+	// closing a Unix listener unlinks the socket file
+	return ds.listener.Close()
+}
+
 // GetCacheStats returns the statistics for cached discovery responses.
 func (ds *DiscoveryService) GetCacheStats(_ *restful.Request, response *restful.Response) {
 	stats := make(map[string]*discoveryCacheStatEntry)
@@ -283,7 +907,60 @@ func (ds *DiscoveryService) GetCacheStats(_ *restful.Request, response *restful.
 	for k, v := range ds.rdsCache.stats() {
 		stats[k] = v
 	}
-	if err := response.WriteEntity(discoveryCacheStats{stats}); err != nil {
+	for k, v := range ds.ldsCache.stats() {
+		stats[k] = v
+	}
+	sizes := map[string]*discoveryCacheSize{
+		"sds": ds.sdsCache.size(),
+		"cds": ds.cdsCache.size(),
+		"rds": ds.rdsCache.size(),
+		"lds": ds.ldsCache.size(),
+	}
+	if err := response.WriteEntity(discoveryCacheStats{Stats: stats, Sizes: sizes}); err != nil {
+		glog.Warning(err)
+	}
+}
+
+// DrainNode marks the endpoints of a proxy node as draining in SDS responses
+// until the drain duration expires. Envoy v1 SDS responses omit draining
+// endpoints, since v1 endpoint weights cannot be zero, and v2 responses report
+// them with the DRAINING health status. Draining requires profiling.
+func (ds *DiscoveryService) DrainNode(request *restful.Request, response *restful.Response) {
+	if !ds.profiling {
+		errorResponse(response, http.StatusForbidden, "Draining requires profiling")
+		return
+	}
+	ip := request.PathParameter(ServiceNode)
+	if net.ParseIP(ip) == nil {
+		errorResponse(response, http.StatusBadRequest,
+			fmt.Sprintf("Unexpected %s %q", ServiceNode, ip))
+		return
+	}
+	duration := DefaultDrainDuration
+	if param := request.QueryParameter(DrainDuration); param != "" {
+		var err error
+		if duration, err = time.ParseDuration(param); err != nil || duration <= 0 {
+			errorResponse(response, http.StatusBadRequest,
+				fmt.Sprintf("Unexpected %s %q", DrainDuration, param))
+			return
+		}
+		if duration > MaxDrainDuration {
+			errorResponse(response, http.StatusBadRequest,
+				fmt.Sprintf("%s %v exceeds the maximum %v", DrainDuration, duration, MaxDrainDuration))
+			return
+		}
+	}
+
+	glog.Infof("Draining proxy node %s for %v", ip, duration)
+	// cached responses must not outlive the drain, but draining does not
+	// change the configuration served to the proxies
+	ds.drains.mark(ip, duration, ds.flushCache)
+	ds.flushCache()
+}
+
+// ListProxies returns the proxies that recently requested clusters or routes.
+func (ds *DiscoveryService) ListProxies(_ *restful.Request, response *restful.Response) {
+	if err := response.WriteEntity(ds.proxies.list(atomic.LoadUint64(&ds.configGeneration))); err != nil {
 		glog.Warning(err)
 	}
 }
@@ -293,44 +970,266 @@ func (ds *DiscoveryService) ClearCacheStats(_ *restful.Request, _ *restful.Respo
 	ds.sdsCache.resetStats()
 	ds.cdsCache.resetStats()
 	ds.rdsCache.resetStats()
+	ds.ldsCache.resetStats()
 }
 
+func (ds *DiscoveryService) auditEvent(e model.Event, kind, key string) {
+	if ds.audit != nil {
+		ds.audit(AuditEvent{Event: e, Kind: kind, Key: key, Time: time.Now()})
+	}
+}
+
+// clearCache counts a service, instance, or config change and flushes the
+// cached responses
 func (ds *DiscoveryService) clearCache() {
+	atomic.AddUint64(&ds.configGeneration, 1)
+	ds.flushCache()
+}
+
+// flushCache drops the cached responses without counting a config change
+func (ds *DiscoveryService) flushCache() {
 	glog.Infof("Cleared discovery service cache")
 	ds.sdsCache.clear()
 	ds.cdsCache.clear()
 	ds.rdsCache.clear()
+	ds.ldsCache.clear()
 }
 
+// MaxServiceKeyLength is the longest service key accepted in the SDS request
+// path. Longer keys, e.g. with many tags, must be sent in a POST request body.
+const MaxServiceKeyLength = 2048
+
+// MaxServiceKeyBodyLength is the largest SDS request body accepted
+const MaxServiceKeyBodyLength = 8 << 10
+
 // ListEndpoints responds to SDS requests
 func (ds *DiscoveryService) ListEndpoints(request *restful.Request, response *restful.Response) {
-	key := request.Request.URL.String()
-	out, cached := ds.sdsCache.cachedDiscoveryResponse(key)
+	serviceKey := request.PathParameter(ServiceKey)
+	if len(serviceKey) > MaxServiceKeyLength {
+		errorResponse(response, http.StatusRequestURITooLong,
+			fmt.Sprintf("%s longer than %d characters, use POST /v1/registration instead",
+				ServiceKey, MaxServiceKeyLength))
+		return
+	}
+	ds.listEndpoints(request, response, request.Request.URL.String(), serviceKey)
+}
+
+// ListEndpointsByBody responds to SDS requests with the service key in the request body
+func (ds *DiscoveryService) ListEndpointsByBody(request *restful.Request, response *restful.Response) {
+	reader := http.MaxBytesReader(response.ResponseWriter, request.Request.Body, MaxServiceKeyBodyLength)
+	body, err := ioutil.ReadAll(reader)
+	if err != nil && len(body) == MaxServiceKeyBodyLength {
+		errorResponse(response, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Request body larger than %d bytes", MaxServiceKeyBodyLength))
+		return
+	} else if err != nil {
+		errorResponse(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	serviceKey := strings.TrimSpace(string(body))
+	if serviceKey == "" {
+		errorResponse(response, http.StatusBadRequest, fmt.Sprintf("Missing %s in request body", ServiceKey))
+		return
+	}
+	ds.listEndpoints(request, response, "POST "+request.Request.URL.String()+" "+serviceKey, serviceKey)
+}
+
+func (ds *DiscoveryService) listEndpoints(request *restful.Request, response *restful.Response,
+	key, serviceKey string) {
+	format := ds.sdsFormat
+	if strings.Contains(request.HeaderParameter("Accept"), MIMEEnvoyV2) {
+		format = SDSFormatV2
+	}
+	if format != SDSFormatV1 {
+		key = format + " " + key
+	}
+	out, etag, generation, cached := ds.sdsCache.cachedDiscoveryResponse(key)
 	if !cached {
-		hostname, ports, tags := model.ParseServiceKey(request.PathParameter(ServiceKey))
-		// envoy expects an empty array if no hosts are available
-		hostArray := make([]*host, 0)
-		for _, ep := range ds.services.Instances(hostname, ports.GetNames(), tags) {
-			hostArray = append(hostArray, &host{
-				Address: ep.Endpoint.Address,
-				Port:    ep.Endpoint.Port,
-			})
+		hostname, ports, tags := model.ParseServiceKey(serviceKey)
+		var instances []*model.ServiceInstance
+		if !ds.callRegistry(response, func() {
+			instances = ds.services.Instances(hostname, ports.GetNames(), tags)
+		}) {
+			return
 		}
+		draining := ds.drains.draining()
 		var err error
-		if out, err = json.MarshalIndent(hosts{Hosts: hostArray}, " ", " "); err != nil {
+		if format == SDSFormatV2 {
+			out, err = json.MarshalIndent(buildLocalityLbEndpoints(serviceKey, instances, draining), " ", " ")
+		} else {
+			out, err = json.MarshalIndent(buildHosts(activeInstances(instances, draining)), " ", " ")
+		}
+		if err != nil {
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.sdsCache.updateCachedDiscoveryResponse(key, out)
+		etag = ds.sdsCache.updateCachedDiscoveryResponse(key, generation, out)
+	}
+	ds.writeResponse(request, response, out, etag)
+}
+
+// buildHosts produces the v1 SDS response
+func buildHosts(instances []*model.ServiceInstance) hosts {
+	// envoy expects an empty array if no hosts are available
+	hostArray := make([]*host, 0)
+	for _, ep := range instances {
+		hostArray = append(hostArray, &host{
+			Address: ep.Endpoint.Address,
+			Port:    ep.Endpoint.Port,
+			Weight:  endpointWeight(ep),
+		})
+	}
+	return hosts{Hosts: hostArray}
+}
+
+// endpointWeightedRoutes lists the routes of a rule splitting traffic by
+// weight that select instances with a WeightTag. The route weights split the
+// traffic across the subsets, and the endpoint weights then skew it again
+// within each subset, so the endpoints do not get the declared percentages.
+func endpointWeightedRoutes(rule *proxyconfig.RouteRule, discovery model.ServiceDiscovery) []string {
+	out := make([]string, 0)
+	if len(rule.Route) < 2 {
+		return out
+	}
+	for _, route := range rule.Route {
+		destination := route.Destination
+		if destination == "" {
+			destination = rule.Destination
+		}
+		service, ok := discovery.GetService(destination)
+		if !ok {
+			continue
+		}
+		for _, instance := range discovery.Instances(destination, service.Ports.GetNames(),
+			model.TagsList{route.Tags}) {
+			if _, weighted := instance.Tags[WeightTag]; weighted {
+				out = append(out, fmt.Sprintf("Route rule for destination %q sends %d%% of traffic to tags %v, "+
+					"which select endpoints weighted by %s: the endpoint weights apply on top of the route weight",
+					rule.Destination, route.Weight, model.Tags(route.Tags), WeightTag))
+				break
+			}
+		}
+	}
+	return out
+}
+
+// endpointWeight parses the weight tag of an instance, or returns zero
+// (no weight) if the tag is absent or out of range
+func endpointWeight(instance *model.ServiceInstance) int {
+	value, ok := instance.Tags[WeightTag]
+	if !ok {
+		return 0
+	}
+	weight, err := strconv.Atoi(value)
+	if err != nil || weight < 1 || weight > 100 {
+		glog.Warningf("Ignoring %s %q for endpoint %s:%d: must be an integer in [1, 100]",
+			WeightTag, value, instance.Endpoint.Address, instance.Endpoint.Port)
+		return 0
+	}
+	return weight
+}
+
+// activeInstances leaves out the instances with draining endpoint addresses
+func activeInstances(instances []*model.ServiceInstance, draining map[string]bool) []*model.ServiceInstance {
+	if len(draining) == 0 {
+		return instances
+	}
+	out := make([]*model.ServiceInstance, 0, len(instances))
+	for _, instance := range instances {
+		if !draining[instance.Endpoint.Address] {
+			out = append(out, instance)
+		}
+	}
+	return out
+}
+
+// buildLocalityLbEndpoints produces the v2 SDS response. Endpoints are grouped
+// by the region and zone tags of the instances, and each locality is weighted
+// by the number of its endpoints. Instance tags are passed as endpoint metadata,
+// and endpoints with draining addresses are reported as draining.
+func buildLocalityLbEndpoints(cluster string, instances []*model.ServiceInstance,
+	draining map[string]bool) localityLbEndpoints {
+	out := localityLbEndpoints{
+		ClusterName: cluster,
+		Endpoints:   make([]*localityLbEndpoint, 0),
+	}
+	byLocality := make(map[locality]*localityLbEndpoint)
+	for _, instance := range instances {
+		loc := locality{
+			Region: instance.Tags[RegionTag],
+			Zone:   instance.Tags[ZoneTag],
+		}
+		group, ok := byLocality[loc]
+		if !ok {
+			group = &localityLbEndpoint{Locality: loc}
+			byLocality[loc] = group
+			out.Endpoints = append(out.Endpoints, group)
+		}
+		group.LbEndpoints = append(group.LbEndpoints, &lbEndpoint{
+			Endpoint: endpoint{Address: address{SocketAddress: socketAddress{
+				Address:   instance.Endpoint.Address,
+				PortValue: instance.Endpoint.Port,
+			}}},
+			Metadata: buildEndpointMetadata(instance),
+			Weight:   1,
+		})
+		if draining[instance.Endpoint.Address] {
+			group.LbEndpoints[len(group.LbEndpoints)-1].HealthStatus = HealthStatusDraining
+		}
+		group.Weight++
+	}
+	sort.Sort(localitiesByName(out.Endpoints))
+	return out
+}
+
+// buildEndpointMetadata exposes the instance tags and the endpoint SNI as
+// endpoint metadata, or nothing if there are neither. Malformed tags are skipped.
+func buildEndpointMetadata(instance *model.ServiceInstance) *metadata {
+	out := &metadata{FilterMetadata: make(map[string]model.Tags)}
+	if len(instance.Tags) > 0 {
+		if err := instance.Tags.Validate(); err != nil {
+			glog.Warningf("Skipping metadata for endpoint %s:%d: %v",
+				instance.Endpoint.Address, instance.Endpoint.Port, err)
+		} else {
+			out.FilterMetadata[LbMetadataNamespace] = instance.Tags
+		}
+	}
+	if instance.Endpoint.SNI != "" {
+		out.FilterMetadata[TLSMetadataNamespace] = model.Tags{SNIMetadataKey: instance.Endpoint.SNI}
+	}
+	if len(out.FilterMetadata) == 0 {
+		return nil
+	}
+	return out
+}
+
+// localitiesByName implements sort by region and zone
+type localitiesByName []*localityLbEndpoint
+
+func (s localitiesByName) Len() int {
+	return len(s)
+}
+
+func (s localitiesByName) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s localitiesByName) Less(i, j int) bool {
+	a, b := s[i].Locality, s[j].Locality
+	if a.Region != b.Region {
+		return a.Region < b.Region
 	}
-	writeResponse(response, out)
+	return a.Zone < b.Zone
 }
 
 // ListClusters responds to CDS requests for all outbound clusters
 func (ds *DiscoveryService) ListClusters(request *restful.Request, response *restful.Response) {
 	key := request.Request.URL.String()
-	out, cached := ds.cdsCache.cachedDiscoveryResponse(key)
+	// read before the response is computed, so a concurrent change marks the proxy as lagging
+	configGeneration := atomic.LoadUint64(&ds.configGeneration)
+	out, etag, generation, cached := ds.cdsCache.cachedDiscoveryResponse(key)
 	if !cached {
+		var err error
 		if sc := request.PathParameter(ServiceCluster); sc != ds.mesh.IstioServiceCluster {
 			errorResponse(response, http.StatusNotFound,
 				fmt.Sprintf("Unexpected %s %q", ServiceCluster, sc))
@@ -339,35 +1238,60 @@ func (ds *DiscoveryService) ListClusters(request *restful.Request, response *res
 
 		// service-node holds the IP address
 		ip := request.PathParameter(ServiceNode)
+		if net.ParseIP(ip) == nil {
+			errorResponse(response, http.StatusBadRequest,
+				fmt.Sprintf("Unexpected %s %q", ServiceNode, ip))
+			return
+		}
 		// CDS computes clusters that are referenced by RDS routes for a particular proxy node
 		// TODO: this implementation is inefficient as it is recomputing all the routes for all proxies
 		// There is a lot of potential to cache and reuse cluster definitions across proxies and also
 		// skip computing the actual HTTP routes
-		instances := ds.services.HostInstances(map[string]bool{ip: true})
-		services := ds.services.Services()
-		httpRouteConfigs := buildOutboundHTTPRoutes(instances, services, &ProxyContext{
-			Discovery:  ds.services,
-			Config:     ds.config,
-			MeshConfig: ds.mesh,
-			IPAddress:  ip,
-		})
+		var httpRouteConfigs HTTPRouteConfigs
+		if !ds.callRegistry(response, func() {
+			instances := ds.services.HostInstances(map[string]bool{ip: true})
+			services := ds.nodeServices(instances)
+			httpRouteConfigs = buildOutboundHTTPRoutes(instances, services, &ProxyContext{
+				Discovery:  ds.services,
+				Config:     ds.config,
+				MeshConfig: ds.mesh,
+				IPAddress:  ip,
+			})
+		}) {
+			return
+		}
 
 		// de-duplicate and canonicalize clusters
 		clusters := httpRouteConfigs.clusters().normalize()
 
-		// apply custom policies for HTTP clusters
-		for _, cluster := range clusters {
-			insertDestinationPolicy(ds.config, cluster)
+		// apply custom policies for HTTP clusters, unless a raw view is requested
+		raw := false
+		if param := request.QueryParameter(Raw); param != "" {
+			if raw, err = strconv.ParseBool(param); err != nil {
+				errorResponse(response, http.StatusBadRequest,
+					fmt.Sprintf("Unexpected %s %q", Raw, param))
+				return
+			}
+			if raw && !ds.profiling {
+				errorResponse(response, http.StatusForbidden,
+					fmt.Sprintf("Query parameter %s requires profiling", Raw))
+				return
+			}
+		}
+		if !raw {
+			for _, cluster := range clusters {
+				insertDestinationPolicy(ds.config, cluster)
+			}
 		}
 
-		var err error
 		if out, err = json.MarshalIndent(ClusterManager{Clusters: clusters}, " ", " "); err != nil {
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.cdsCache.updateCachedDiscoveryResponse(key, out)
+		etag = ds.cdsCache.updateCachedDiscoveryResponse(key, generation, out)
 	}
-	writeResponse(response, out)
+	ds.proxies.record(request.PathParameter(ServiceCluster), request.PathParameter(ServiceNode), configGeneration)
+	ds.writeResponse(request, response, out, etag)
 }
 
 // ListRoutes responds to RDS requests, used by HTTP routes
@@ -375,7 +1299,9 @@ func (ds *DiscoveryService) ListClusters(request *restful.Request, response *res
 // to identify HTTP filters in the config. Service node value holds the local proxy identity.
 func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restful.Response) {
 	key := request.Request.URL.String()
-	out, cached := ds.rdsCache.cachedDiscoveryResponse(key)
+	// read before the response is computed, so a concurrent change marks the proxy as lagging
+	configGeneration := atomic.LoadUint64(&ds.configGeneration)
+	out, etag, generation, cached := ds.rdsCache.cachedDiscoveryResponse(key)
 	if !cached {
 		if sc := request.PathParameter(ServiceCluster); sc != ds.mesh.IstioServiceCluster {
 			errorResponse(response, http.StatusNotFound,
@@ -384,6 +1310,11 @@ func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restf
 		}
 		// service-node holds the IP address
 		ip := request.PathParameter(ServiceNode)
+		if net.ParseIP(ip) == nil {
+			errorResponse(response, http.StatusBadRequest,
+				fmt.Sprintf("Unexpected %s %q", ServiceNode, ip))
+			return
+		}
 
 		// route-config-name holds the listener port
 		routeConfigName := request.PathParameter(RouteConfigName)
@@ -394,14 +1325,19 @@ func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restf
 			return
 		}
 
-		instances := ds.services.HostInstances(map[string]bool{ip: true})
-		services := ds.services.Services()
-		httpRouteConfigs := buildOutboundHTTPRoutes(instances, services, &ProxyContext{
-			Discovery:  ds.services,
-			Config:     ds.config,
-			MeshConfig: ds.mesh,
-			IPAddress:  ip,
-		})
+		var httpRouteConfigs HTTPRouteConfigs
+		if !ds.callRegistry(response, func() {
+			instances := ds.services.HostInstances(map[string]bool{ip: true})
+			services := ds.nodeServices(instances)
+			httpRouteConfigs = buildOutboundHTTPRoutes(instances, services, &ProxyContext{
+				Discovery:  ds.services,
+				Config:     ds.config,
+				MeshConfig: ds.mesh,
+				IPAddress:  ip,
+			})
+		}) {
+			return
+		}
 
 		routeConfig, ok := httpRouteConfigs[port]
 		if !ok {
@@ -413,9 +1349,137 @@ func (ds *DiscoveryService) ListRoutes(request *restful.Request, response *restf
 			errorResponse(response, http.StatusInternalServerError, err.Error())
 			return
 		}
-		ds.rdsCache.updateCachedDiscoveryResponse(key, out)
+		etag = ds.rdsCache.updateCachedDiscoveryResponse(key, generation, out)
 	}
-	writeResponse(response, out)
+	ds.proxies.record(request.PathParameter(ServiceCluster), request.PathParameter(ServiceNode), configGeneration)
+	ds.writeResponse(request, response, out, etag)
+}
+
+// ldsResponse is the Envoy LDS response
+type ldsResponse struct {
+	Listeners Listeners `json:"listeners"`
+}
+
+// ListListeners responds to LDS requests with the inbound and outbound
+// listeners of the proxy node. Service node value holds the local proxy identity.
+// The listeners do not bind to ports since traffic is redirected by the proxy
+// listener in the bootstrap config, which also defines the inbound and TCP clusters.
+func (ds *DiscoveryService) ListListeners(request *restful.Request, response *restful.Response) {
+	key := request.Request.URL.String()
+	// read before the response is computed, so a concurrent change marks the proxy as lagging
+	configGeneration := atomic.LoadUint64(&ds.configGeneration)
+	out, etag, generation, cached := ds.ldsCache.cachedDiscoveryResponse(key)
+	if !cached {
+		if sc := request.PathParameter(ServiceCluster); sc != ds.mesh.IstioServiceCluster {
+			errorResponse(response, http.StatusNotFound,
+				fmt.Sprintf("Unexpected %s %q", ServiceCluster, sc))
+			return
+		}
+		// service-node holds the IP address
+		ip := request.PathParameter(ServiceNode)
+		if net.ParseIP(ip) == nil {
+			errorResponse(response, http.StatusBadRequest,
+				fmt.Sprintf("Unexpected %s %q", ServiceNode, ip))
+			return
+		}
+
+		var listeners Listeners
+		if !ds.callRegistry(response, func() {
+			instances := ds.services.HostInstances(map[string]bool{ip: true})
+			services := ds.nodeServices(instances)
+			context := &ProxyContext{
+				Discovery:  ds.services,
+				Config:     ds.config,
+				MeshConfig: ds.mesh,
+				IPAddress:  ip,
+			}
+			inbound, _ := buildInboundListeners(instances, ds.mesh)
+			outbound, _ := buildOutboundListeners(instances, services, context)
+			listeners = append(inbound, outbound...)
+			listeners.normalize()
+			insertMixerFilter(listeners, instances, context)
+		}) {
+			return
+		}
+		for _, listener := range listeners {
+			listener.BindToPort = false
+		}
+
+		var err error
+		if out, err = json.MarshalIndent(ldsResponse{Listeners: listeners}, " ", " "); err != nil {
+			errorResponse(response, http.StatusInternalServerError, err.Error())
+			return
+		}
+		etag = ds.ldsCache.updateCachedDiscoveryResponse(key, generation, out)
+	}
+	ds.proxies.record(request.PathParameter(ServiceCluster), request.PathParameter(ServiceNode), configGeneration)
+	ds.writeResponse(request, response, out, etag)
+}
+
+// MaxRegistryCalls bounds the discovery computations running at once with a
+// registry timeout, including those that timed out and are still blocked on
+// the service registry
+const MaxRegistryCalls = 64
+
+// callRegistry runs a computation that calls the service registry, waiting
+// at most the registry timeout. On a timeout, it responds with
+// http.StatusServiceUnavailable and returns false; the computation is left
+// to finish in the background and its results must not be used. Requests
+// fail right away while MaxRegistryCalls computations are still running, so
+// that a stuck registry does not pile up blocked computations.
+func (ds *DiscoveryService) callRegistry(response *restful.Response, f func()) bool {
+	if ds.registryTimeout <= 0 {
+		f()
+		return true
+	}
+	select {
+	case ds.registryCalls <- struct{}{}:
+	default:
+		errorResponse(response, http.StatusServiceUnavailable,
+			fmt.Sprintf("Service registry has %d pending calls", cap(ds.registryCalls)))
+		return false
+	}
+	done := make(chan struct{})
+	go func() {
+		defer func() { <-ds.registryCalls }()
+		defer close(done)
+		f()
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(ds.registryTimeout):
+		errorResponse(response, http.StatusServiceUnavailable,
+			fmt.Sprintf("Service registry did not respond within %v", ds.registryTimeout))
+		return false
+	}
+}
+
+// nodeServices lists the services that outbound clusters and routes are
+// generated for the proxy node with the given instances
+func (ds *DiscoveryService) nodeServices(instances []*model.ServiceInstance) []*model.Service {
+	services := ds.services.Services()
+	if !ds.scoped {
+		return services
+	}
+
+	referenced := make(map[string]bool)
+	for _, rule := range ds.config.RouteRulesBySource("", instances) {
+		referenced[rule.Destination] = true
+		for _, route := range rule.Route {
+			if route.Destination != "" {
+				referenced[route.Destination] = true
+			}
+		}
+	}
+
+	out := make([]*model.Service, 0, len(referenced))
+	for _, service := range services {
+		if referenced[service.Hostname] {
+			out = append(out, service)
+		}
+	}
+	return out
 }
 
 func errorResponse(r *restful.Response, status int, msg string) {
@@ -425,9 +1489,67 @@ func errorResponse(r *restful.Response, status int, msg string) {
 	}
 }
 
-func writeResponse(r *restful.Response, data []byte) {
+// writeResponse writes the discovery response with its entity tag, or an
+// empty response with http.StatusNotModified if the tag matches the
+// If-None-Match request header. Large responses are compressed for clients
+// accepting gzip; the cached data is never compressed, so that it serves
+// all clients.
+func (ds *DiscoveryService) writeResponse(request *restful.Request, r *restful.Response, data []byte, etag string) {
+	r.AddHeader("ETag", etag)
+	if ds.compressionThreshold > 0 {
+		r.AddHeader("Vary", "Accept-Encoding")
+	}
+	if etagMatches(request.HeaderParameter("If-None-Match"), etag) {
+		r.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if ds.compressionThreshold > 0 && len(data) > ds.compressionThreshold &&
+		acceptsGzip(request.HeaderParameter("Accept-Encoding")) {
+		r.AddHeader("Content-Encoding", "gzip")
+		r.WriteHeader(http.StatusOK)
+		w := gzip.NewWriter(r)
+		if _, err := w.Write(data); err != nil {
+			glog.Warning(err)
+		}
+		if err := w.Close(); err != nil {
+			glog.Warning(err)
+		}
+		return
+	}
 	r.WriteHeader(http.StatusOK)
 	if _, err := r.Write(data); err != nil {
 		glog.Warning(err)
 	}
 }
+
+// acceptsGzip checks whether the Accept-Encoding header value allows gzip
+func acceptsGzip(header string) bool {
+	for _, encoding := range strings.Split(header, ",") {
+		parts := strings.Split(encoding, ";")
+		name := strings.TrimSpace(parts[0])
+		if name != "gzip" && name != "*" {
+			continue
+		}
+		// gzip is refused with a zero quality value
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); strings.HasPrefix(param, "q=") &&
+				err == nil && q == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// etagMatches checks whether the If-None-Match header value lists the entity tag
+func etagMatches(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -15,11 +15,20 @@
 package envoy
 
 import (
+	"compress/gzip"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	restful "github.com/emicklei/go-restful"
 	"github.com/golang/protobuf/proto"
@@ -44,6 +53,11 @@ func (mockController) AppendInstanceHandler(_ func(*model.ServiceInstance, model
 	return nil
 }
 func (mockController) Run(_ <-chan struct{}) {}
+func (mockController) Ready() <-chan struct{} {
+	ready := make(chan struct{})
+	close(ready)
+	return ready
+}
 
 func makeDiscoveryService(t *testing.T, r *model.IstioRegistry) *DiscoveryService {
 	out, err := NewDiscoveryService(DiscoveryServiceOptions{
@@ -123,6 +137,215 @@ func TestServiceDiscoveryEmpty(t *testing.T) {
 	compareResponse(response, "testdata/sds-empty.json", t)
 }
 
+func TestServiceDiscoveryV2(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	url := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	httpRequest, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpRequest.Header.Set("Accept", MIMEEnvoyV2)
+	httpWriter := httptest.NewRecorder()
+	container := restful.NewContainer()
+	ds.Register(container)
+	container.ServeHTTP(httpWriter, httpRequest)
+	response, err := ioutil.ReadAll(httpWriter.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(response, "testdata/sds-v2.json", t)
+
+	// v1 format remains the default for the same request without the header
+	response = makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/sds.json", t)
+}
+
+func TestServiceDiscoveryLongKey(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	container := restful.NewContainer()
+	ds.Register(container)
+
+	tags := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		tags[fmt.Sprintf("tag%d", i)] = fmt.Sprintf("value%d", i)
+	}
+	longKey := mock.HelloService.Key(mock.HelloService.Ports[0], tags)
+	shortKey := mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+
+	cases := []struct {
+		method string
+		url    string
+		body   string
+		code   int
+	}{
+		{method: "GET", url: "/v1/registration/" + longKey, code: http.StatusRequestURITooLong},
+		{method: "POST", url: "/v1/registration", body: longKey, code: http.StatusOK},
+		{method: "POST", url: "/v1/registration", body: shortKey, code: http.StatusOK},
+		{method: "POST", url: "/v1/registration", body: "", code: http.StatusBadRequest},
+		{method: "POST", url: "/v1/registration", body: strings.Repeat(shortKey, MaxServiceKeyBodyLength),
+			code: http.StatusRequestEntityTooLarge},
+	}
+	for _, c := range cases {
+		httpRequest, err := http.NewRequest(c.method, c.url, strings.NewReader(c.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpRequest.Header.Set("Content-Type", "text/plain")
+		httpWriter := httptest.NewRecorder()
+		container.ServeHTTP(httpWriter, httpRequest)
+		if httpWriter.Code != c.code {
+			t.Errorf("%s %s => got status %d, want %d", c.method, c.url, httpWriter.Code, c.code)
+		}
+	}
+
+	httpRequest, err := http.NewRequest("POST", "/v1/registration", strings.NewReader(shortKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpRequest.Header.Set("Content-Type", "text/plain")
+	httpWriter := httptest.NewRecorder()
+	container.ServeHTTP(httpWriter, httpRequest)
+	response, err := ioutil.ReadAll(httpWriter.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(response, "testdata/sds.json", t)
+}
+
+func TestServiceDiscoveryDrain(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	now := time.Now()
+	ds.drains.now = func() time.Time { return now }
+	url := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+
+	// populate the cache before draining
+	if response := makeDiscoveryRequest(ds, "GET", url, t); !strings.Contains(string(response), mock.HostInstanceV0) {
+		t.Fatalf("SDS response %s should list %s", response, mock.HostInstanceV0)
+	}
+
+	generation := atomic.LoadUint64(&ds.configGeneration)
+	container := restful.NewContainer()
+	ds.Register(container)
+	for _, c := range []struct {
+		url  string
+		code int
+	}{
+		{url: "/v1/drain/garbage", code: http.StatusBadRequest},
+		{url: "/v1/drain/" + mock.HostInstanceV0 + "?duration=never", code: http.StatusBadRequest},
+		{url: "/v1/drain/" + mock.HostInstanceV0 + "?duration=2h", code: http.StatusBadRequest},
+		{url: "/v1/drain/" + mock.HostInstanceV0 + "?duration=30m", code: http.StatusOK},
+		{url: "/v1/drain/" + mock.HostInstanceV0 + "?duration=1h", code: http.StatusOK},
+	} {
+		httpRequest, err := http.NewRequest("POST", c.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpWriter := httptest.NewRecorder()
+		container.ServeHTTP(httpWriter, httpRequest)
+		if httpWriter.Code != c.code {
+			t.Errorf("POST %s => got status %d, want %d", c.url, httpWriter.Code, c.code)
+		}
+	}
+
+	// marking the node again extends the drain with the same timer
+	if len(ds.drains.timers) != 1 {
+		t.Errorf("got %d drain timers, want one", len(ds.drains.timers))
+	}
+
+	// draining is not a config change, so served proxies do not lag
+	if got := atomic.LoadUint64(&ds.configGeneration); got != generation {
+		t.Errorf("config generation after draining => got %d, want %d", got, generation)
+	}
+
+	// v1 omits the draining endpoints
+	response := string(makeDiscoveryRequest(ds, "GET", url, t))
+	if strings.Contains(response, mock.HostInstanceV0) || !strings.Contains(response, mock.HostInstanceV1) {
+		t.Errorf("SDS response %s should list only %s", response, mock.HostInstanceV1)
+	}
+
+	// v2 reports them as draining
+	hostname, ports, tags := model.ParseServiceKey(mock.HelloService.Key(mock.HelloService.Ports[0], nil))
+	instances := mock.Discovery.Instances(hostname, ports.GetNames(), tags)
+	out := buildLocalityLbEndpoints("hello", instances, ds.drains.draining())
+	for _, group := range out.Endpoints {
+		for _, ep := range group.LbEndpoints {
+			drained := ep.HealthStatus == HealthStatusDraining
+			if want := ep.Endpoint.Address.SocketAddress.Address == mock.HostInstanceV0; drained != want {
+				t.Errorf("endpoint %v => got draining %v, want %v", ep.Endpoint, drained, want)
+			}
+		}
+	}
+
+	// the mark expires
+	now = now.Add(time.Hour + time.Second)
+	if draining := ds.drains.draining(); len(draining) != 0 {
+		t.Errorf("got draining nodes %v after expiry, want none", draining)
+	}
+}
+
+func TestServiceDiscoveryDrainRequiresProfiling(t *testing.T) {
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   mock.Discovery,
+		Controller: &mockController{},
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := restful.NewContainer()
+	ds.Register(container)
+	httpRequest, err := http.NewRequest("POST", "/v1/drain/"+mock.HostInstanceV0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpWriter := httptest.NewRecorder()
+	container.ServeHTTP(httpWriter, httpRequest)
+	if httpWriter.Code != http.StatusForbidden {
+		t.Errorf("POST /v1/drain without profiling => got status %d, want %d", httpWriter.Code, http.StatusForbidden)
+	}
+	if draining := ds.drains.draining(); len(draining) != 0 {
+		t.Errorf("got draining nodes %v without profiling, want none", draining)
+	}
+}
+
+func TestServiceDiscoveryV2Option(t *testing.T) {
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   mock.Discovery,
+		Controller: &mockController{},
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+		SDSFormat:  SDSFormatV2,
+	})
+	if err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+	url := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	response := makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/sds-v2.json", t)
+
+	if _, err = NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   mock.Discovery,
+		Controller: &mockController{},
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+		SDSFormat:  "v3",
+	}); err == nil {
+		t.Error("expected error for unknown SDS format")
+	}
+
+	mesh := DefaultMeshConfig
+	mesh.IstioServiceCluster = ""
+	if _, err = NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   mock.Discovery,
+		Controller: &mockController{},
+		Config:     mock.MakeRegistry(),
+		Mesh:       &mesh,
+	}); err == nil {
+		t.Error("expected error for an invalid mesh config")
+	}
+}
+
 func TestClusterDiscovery(t *testing.T) {
 	registry := mock.MakeRegistry()
 	ds := makeDiscoveryService(t, registry)
@@ -131,6 +354,244 @@ func TestClusterDiscovery(t *testing.T) {
 	compareResponse(response, "testdata/cds.json", t)
 }
 
+// externalDiscovery adds an external service to the mock services
+type externalDiscovery struct {
+	model.ServiceDiscovery
+	external *model.Service
+}
+
+func (d externalDiscovery) Services() []*model.Service {
+	return append(d.ServiceDiscovery.Services(), d.external)
+}
+
+func (d externalDiscovery) GetService(hostname string) (*model.Service, bool) {
+	if hostname == d.external.Hostname {
+		return d.external, true
+	}
+	return d.ServiceDiscovery.GetService(hostname)
+}
+
+func TestClusterDiscoveryExternal(t *testing.T) {
+	const external = "out.google.default.svc.cluster.local|http"
+	auth := DefaultMeshConfig
+	auth.AuthPolicy = proxyconfig.ProxyMeshConfig_MUTUAL_TLS
+	cases := []struct {
+		mesh   *proxyconfig.ProxyMeshConfig
+		golden string
+	}{
+		{mesh: &DefaultMeshConfig, golden: "testdata/cds-external.json"},
+		{mesh: &auth, golden: "testdata/cds-external-auth.json"},
+	}
+	for _, c := range cases {
+		ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+			Services: externalDiscovery{
+				ServiceDiscovery: mock.Discovery,
+				external: &model.Service{
+					Hostname:     "google.default.svc.cluster.local",
+					ExternalName: "www.google.com",
+					Ports:        model.PortList{{Name: "http", Port: 80, Protocol: model.ProtocolHTTP}},
+				},
+			},
+			Controller: &mockController{},
+			Config:     mock.MakeRegistry(),
+			Mesh:       c.mesh,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+		response := makeDiscoveryRequest(ds, "GET", url, t)
+		compareResponse(response, c.golden, t)
+
+		var clusters ClusterManager
+		if err := json.Unmarshal(response, &clusters); err != nil {
+			t.Fatal(err)
+		}
+		for _, cluster := range clusters.Clusters {
+			if cluster.Name == external && cluster.SSLContext != nil {
+				t.Errorf("%s: external cluster %s has an SSL context %+v", c.golden, cluster.Name, cluster.SSLContext)
+			}
+			if cluster.Name != external && c.mesh.AuthPolicy == proxyconfig.ProxyMeshConfig_MUTUAL_TLS &&
+				cluster.SSLContext == nil {
+				t.Errorf("%s: mesh cluster %s has no SSL context", c.golden, cluster.Name)
+			}
+		}
+	}
+}
+
+// blockingDiscovery blocks instance lookups until unblocked
+type blockingDiscovery struct {
+	model.ServiceDiscovery
+	unblock chan struct{}
+}
+
+func (d blockingDiscovery) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	<-d.unblock
+	return d.ServiceDiscovery.Instances(hostname, ports, tags)
+}
+
+func (d blockingDiscovery) HostInstances(addrs map[string]bool) []*model.ServiceInstance {
+	<-d.unblock
+	return d.ServiceDiscovery.HostInstances(addrs)
+}
+
+func TestDiscoveryRegistryTimeout(t *testing.T) {
+	unblock := make(chan struct{})
+	defer close(unblock)
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:        blockingDiscovery{ServiceDiscovery: mock.Discovery, unblock: unblock},
+		Controller:      &mockController{},
+		Config:          mock.MakeRegistry(),
+		Mesh:            &DefaultMeshConfig,
+		RegistryTimeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := restful.NewContainer()
+	ds.Register(container)
+	for _, url := range []string{
+		"/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil),
+		fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0),
+		fmt.Sprintf("/v1/routes/80/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0),
+		fmt.Sprintf("/v1/listeners/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0),
+	} {
+		httpRequest, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpWriter := httptest.NewRecorder()
+		container.ServeHTTP(httpWriter, httpRequest)
+		if httpWriter.Code != http.StatusServiceUnavailable {
+			t.Errorf("GET %s with a blocked registry => got status %d, want %d",
+				url, httpWriter.Code, http.StatusServiceUnavailable)
+		}
+	}
+}
+
+func TestDiscoveryRegistryCallLimit(t *testing.T) {
+	unblock := make(chan struct{})
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:        blockingDiscovery{ServiceDiscovery: mock.Discovery, unblock: unblock},
+		Controller:      &mockController{},
+		Config:          mock.MakeRegistry(),
+		Mesh:            &DefaultMeshConfig,
+		RegistryTimeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds.registryCalls = make(chan struct{}, 2)
+	url := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+
+	// the timed out calls hold on to their slots while the registry is blocked
+	for i := 0; i < 3; i++ {
+		makeDiscoveryRequest(ds, "GET", url, t)
+	}
+	if got := len(ds.registryCalls); got != 2 {
+		t.Errorf("got %d pending registry calls, want 2", got)
+	}
+	if response := string(makeDiscoveryRequest(ds, "GET", url, t)); !strings.Contains(response, "pending calls") {
+		t.Errorf("GET %s with no free registry call => got %q, want pending calls", url, response)
+	}
+
+	// the slots are released once the registry responds
+	close(unblock)
+	for deadline := time.Now().Add(time.Second); len(ds.registryCalls) > 0; {
+		if time.Now().After(deadline) {
+			t.Fatalf("got %d pending registry calls after unblocking, want none", len(ds.registryCalls))
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListProxies(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	now := time.Now()
+	ds.proxies.now = func() time.Time { return now }
+
+	makeDiscoveryRequest(ds, "GET", fmt.Sprintf("/v1/clusters/%s/%s",
+		ds.mesh.IstioServiceCluster, mock.HostInstanceV0), t)
+	makeDiscoveryRequest(ds, "GET", fmt.Sprintf("/v1/routes/80/%s/%s",
+		ds.mesh.IstioServiceCluster, mock.HostInstanceV1), t)
+	// rejected requests are not tracked
+	makeDiscoveryRequest(ds, "GET", fmt.Sprintf("/v1/clusters/%s/%s",
+		ds.mesh.IstioServiceCluster, "garbage"), t)
+
+	var proxies []proxyEntry
+	if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/proxies", t), &proxies); err != nil {
+		t.Fatal(err)
+	}
+	if len(proxies) != 2 {
+		t.Fatalf("got proxies %v, want 2 entries", proxies)
+	}
+	for i, node := range []string{mock.HostInstanceV0, mock.HostInstanceV1} {
+		if proxies[i].ServiceNode != node || proxies[i].ServiceCluster != ds.mesh.IstioServiceCluster ||
+			!proxies[i].LastRequest.Equal(now) {
+			t.Errorf("proxies[%d] => got %v, want node %s seen at %v", i, proxies[i], node, now)
+		}
+	}
+
+	now = now.Add(ProxyExpiry + time.Second)
+	if got := ds.proxies.list(0); len(got) != 0 {
+		t.Errorf("got proxies %v after expiry, want none", got)
+	}
+}
+
+func TestProxyTrackerExpiry(t *testing.T) {
+	tracker := newProxyTracker(time.Minute)
+	now := time.Now()
+	tracker.now = func() time.Time { return now }
+	tracker.record("cluster", "stale", 0)
+	now = now.Add(2 * time.Minute)
+
+	// recording scans for expired proxies only every proxyExpiryRecords requests
+	for i := 1; i < proxyExpiryRecords-1; i++ {
+		tracker.record("cluster", "fresh", 0)
+	}
+	if _, ok := tracker.proxies["cluster stale"]; !ok {
+		t.Error("stale proxy should be kept until the next expiry scan")
+	}
+	tracker.record("cluster", "fresh", 0)
+	if _, ok := tracker.proxies["cluster stale"]; ok {
+		t.Error("stale proxy should be dropped by the expiry scan")
+	}
+	if got := tracker.list(0); len(got) != 1 || got[0].ServiceNode != "fresh" {
+		t.Errorf("list() => got %v, want only the fresh proxy", got)
+	}
+}
+
+func TestListProxiesLagging(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	lagging := func() bool {
+		var proxies []proxyEntry
+		if err := json.Unmarshal(makeDiscoveryRequest(ds, "GET", "/v1/proxies", t), &proxies); err != nil {
+			t.Fatal(err)
+		}
+		if len(proxies) != 1 {
+			t.Fatalf("got proxies %v, want 1 entry", proxies)
+		}
+		return proxies[0].Lagging
+	}
+
+	makeDiscoveryRequest(ds, "GET", url, t)
+	if lagging() {
+		t.Error("proxy served the current generation should not be lagging")
+	}
+
+	// a config change bumps the generation
+	ds.clearCache()
+	if !lagging() {
+		t.Error("proxy served the old generation should be lagging")
+	}
+
+	makeDiscoveryRequest(ds, "GET", url, t)
+	if lagging() {
+		t.Error("proxy should catch up after its next request")
+	}
+}
+
 func TestClusterDiscoveryCircuitBreaker(t *testing.T) {
 	registry := mock.MakeRegistry()
 	addCircuitBreaker(registry, t)
@@ -140,6 +601,55 @@ func TestClusterDiscoveryCircuitBreaker(t *testing.T) {
 	compareResponse(response, "testdata/cds-circuit-breaker.json", t)
 }
 
+func TestClusterDiscoverySubsetCircuitBreaker(t *testing.T) {
+	registry := mock.MakeRegistry()
+	addWeightedRoute(registry, t)
+	addSubsetCircuitBreakers(registry, t)
+	ds := makeDiscoveryService(t, registry)
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response := makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/cds-subset-circuit-breaker.json", t)
+}
+
+func TestClusterDiscoveryRaw(t *testing.T) {
+	registry := mock.MakeRegistry()
+	addCircuitBreaker(registry, t)
+	ds := makeDiscoveryService(t, registry)
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response := makeDiscoveryRequest(ds, "GET", url+"?raw=true", t)
+	compareResponse(response, "testdata/cds.json", t)
+	response = makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/cds-circuit-breaker.json", t)
+
+	// raw view is only available with profiling
+	ds = makeDiscoveryServiceWithSSLContext(t, registry)
+	response = makeDiscoveryRequest(ds, "GET", url+"?raw=true", t)
+	if !strings.Contains(string(response), "requires profiling") {
+		t.Errorf("expected raw query to be rejected, got %q", string(response))
+	}
+}
+
+func TestClusterDiscoveryScoped(t *testing.T) {
+	registry := mock.MakeRegistry()
+	addWeightedRoute(registry, t)
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:      mock.Discovery,
+		Controller:    &mockController{},
+		Config:        registry,
+		Mesh:          &DefaultMeshConfig,
+		ScopeServices: true,
+	})
+	if err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response := makeDiscoveryRequest(ds, "GET", url, t)
+	if strings.Contains(string(response), mock.HelloService.Hostname) {
+		t.Errorf("unreferenced service %s should be omitted from clusters", mock.HelloService.Hostname)
+	}
+	compareResponse(response, "testdata/cds-scoped.json", t)
+}
+
 func TestClusterDiscoveryWithSSLContext(t *testing.T) {
 	registry := mock.MakeRegistry()
 	ds := makeDiscoveryServiceWithSSLContext(t, registry)
@@ -158,6 +668,208 @@ func TestRouteDiscovery(t *testing.T) {
 	compareResponse(response, "testdata/rds-v1.json", t)
 }
 
+func TestListenerDiscovery(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	url := fmt.Sprintf("/v1/listeners/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response := makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/lds.json", t)
+}
+
+func TestDiscoveryETag(t *testing.T) {
+	urls := []string{
+		"/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil),
+		fmt.Sprintf("/v1/clusters/%s/%s", DefaultMeshConfig.IstioServiceCluster, mock.HostInstanceV0),
+		fmt.Sprintf("/v1/routes/80/%s/%s", DefaultMeshConfig.IstioServiceCluster, mock.HostInstanceV0),
+		fmt.Sprintf("/v1/listeners/%s/%s", DefaultMeshConfig.IstioServiceCluster, mock.HostInstanceV0),
+	}
+	for _, caching := range []bool{true, false} {
+		ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+			Services:      mock.Discovery,
+			Controller:    &mockController{},
+			Config:        mock.MakeRegistry(),
+			Mesh:          &DefaultMeshConfig,
+			EnableCaching: caching,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		container := restful.NewContainer()
+		ds.Register(container)
+		request := func(url, etag string) *httptest.ResponseRecorder {
+			httpRequest, err := http.NewRequest("GET", url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if etag != "" {
+				httpRequest.Header.Set("If-None-Match", etag)
+			}
+			httpWriter := httptest.NewRecorder()
+			container.ServeHTTP(httpWriter, httpRequest)
+			return httpWriter
+		}
+
+		for _, url := range urls {
+			first := request(url, "")
+			etag := first.Header().Get("ETag")
+			if first.Code != http.StatusOK || etag == "" {
+				t.Errorf("GET %s (caching=%v) => got status %d ETag %q, want 200 with an ETag",
+					url, caching, first.Code, etag)
+				continue
+			}
+			if got := request(url, etag); got.Code != http.StatusNotModified || got.Body.Len() != 0 {
+				t.Errorf("GET %s (caching=%v) with If-None-Match => got status %d body %q, want 304 and no body",
+					url, caching, got.Code, got.Body.String())
+			}
+			if got := request(url, `"stale"`); got.Code != http.StatusOK || got.Body.String() != first.Body.String() {
+				t.Errorf("GET %s (caching=%v) with a stale If-None-Match => got status %d, want 200 and the full body",
+					url, caching, got.Code)
+			}
+		}
+	}
+}
+
+func TestDiscoveryCompression(t *testing.T) {
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:             mock.Discovery,
+		Controller:           &mockController{},
+		Config:               mock.MakeRegistry(),
+		Mesh:                 &DefaultMeshConfig,
+		EnableCaching:        true,
+		EnableCompression:    true,
+		CompressionThreshold: 512,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := restful.NewContainer()
+	ds.Register(container)
+	request := func(url, encoding string) *httptest.ResponseRecorder {
+		httpRequest, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding != "" {
+			httpRequest.Header.Set("Accept-Encoding", encoding)
+		}
+		httpWriter := httptest.NewRecorder()
+		container.ServeHTTP(httpWriter, httpRequest)
+		return httpWriter
+	}
+
+	url := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	compressed := request(url, "gzip")
+	if got := compressed.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("GET %s accepting gzip => got Content-Encoding %q, want gzip", url, got)
+	}
+	reader, err := gzip.NewReader(compressed.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(body, "testdata/cds.json", t)
+
+	// the cached response is served uncompressed to other clients
+	if plain := request(url, ""); plain.Header().Get("Content-Encoding") != "" || plain.Body.String() != string(body) {
+		t.Errorf("GET %s without gzip => got Content-Encoding %q, want the uncompressed response",
+			url, plain.Header().Get("Content-Encoding"))
+	}
+
+	// responses under the threshold are not compressed
+	url = "/v1/registration/nonexistent"
+	if small := request(url, "gzip"); small.Header().Get("Content-Encoding") != "" {
+		t.Errorf("GET %s accepting gzip => got Content-Encoding %q for a small response",
+			url, small.Header().Get("Content-Encoding"))
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	cases := []struct {
+		header string
+		gzip   bool
+	}{
+		{header: "", gzip: false},
+		{header: "gzip", gzip: true},
+		{header: "deflate, gzip;q=0.8", gzip: true},
+		{header: "*", gzip: true},
+		{header: "gzip;q=0", gzip: false},
+		{header: "identity", gzip: false},
+	}
+	for _, c := range cases {
+		if got := acceptsGzip(c.header); got != c.gzip {
+			t.Errorf("acceptsGzip(%q) => got %v, want %v", c.header, got, c.gzip)
+		}
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	cases := []struct {
+		header string
+		match  bool
+	}{
+		{header: "", match: false},
+		{header: `"a"`, match: true},
+		{header: `"b"`, match: false},
+		{header: `"b", "a"`, match: true},
+		{header: `W/"a"`, match: true},
+		{header: "*", match: true},
+	}
+	for _, c := range cases {
+		if got := etagMatches(c.header, `"a"`); got != c.match {
+			t.Errorf("etagMatches(%q) => got %v, want %v", c.header, got, c.match)
+		}
+	}
+}
+
+func TestDiscoveryServiceNode(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	cases := []struct {
+		url  string
+		code int
+	}{
+		{url: fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0), code: http.StatusOK},
+		{url: fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, "garbage"), code: http.StatusBadRequest},
+		{url: fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, "10.1.1"), code: http.StatusBadRequest},
+		{url: fmt.Sprintf("/v1/routes/80/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0), code: http.StatusOK},
+		{url: fmt.Sprintf("/v1/routes/80/%s/%s", ds.mesh.IstioServiceCluster, "garbage"), code: http.StatusBadRequest},
+		{url: fmt.Sprintf("/v1/listeners/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0), code: http.StatusOK},
+		{url: fmt.Sprintf("/v1/listeners/%s/%s", ds.mesh.IstioServiceCluster, "garbage"), code: http.StatusBadRequest},
+		{url: fmt.Sprintf("/v1/listeners/%s/%s", "unknown", mock.HostInstanceV0), code: http.StatusNotFound},
+	}
+	for _, c := range cases {
+		httpRequest, err := http.NewRequest("GET", c.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		httpWriter := httptest.NewRecorder()
+		container := restful.NewContainer()
+		ds.Register(container)
+		container.ServeHTTP(httpWriter, httpRequest)
+		if httpWriter.Code != c.code {
+			t.Errorf("GET %s => got status %d, want %d", c.url, httpWriter.Code, c.code)
+		}
+	}
+}
+
+func TestRouteDiscoveryMultiPort(t *testing.T) {
+	ds := makeDiscoveryService(t, mock.MakeRegistry())
+	url := fmt.Sprintf("/v1/routes/80/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response := makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/rds-v0.json", t)
+	url = fmt.Sprintf("/v1/routes/81/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response = makeDiscoveryRequest(ds, "GET", url, t)
+	compareResponse(response, "testdata/rds-v0-81.json", t)
+
+	// TCP service ports do not have route configs
+	url = fmt.Sprintf("/v1/routes/90/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	response = makeDiscoveryRequest(ds, "GET", url, t)
+	if !strings.Contains(string(response), "Missing route config for port 90") {
+		t.Errorf("expected missing route config for TCP port, got %q", string(response))
+	}
+}
+
 func TestRouteDiscoveryTimeout(t *testing.T) {
 	registry := mock.MakeRegistry()
 	addTimeout(registry, t)
@@ -197,10 +909,12 @@ func TestDiscoveryCache(t *testing.T) {
 	sds := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
 	cds := fmt.Sprintf("/v1/clusters/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
 	rds := fmt.Sprintf("/v1/routes/80/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
+	lds := fmt.Sprintf("/v1/listeners/%s/%s", ds.mesh.IstioServiceCluster, mock.HostInstanceV0)
 	responseByPath := map[string]string{
 		sds: "testdata/sds.json",
 		cds: "testdata/cds.json",
 		rds: "testdata/rds-v1.json",
+		lds: "testdata/lds.json",
 	}
 
 	cases := []struct {
@@ -253,3 +967,505 @@ func TestDiscoveryCache(t *testing.T) {
 		compareResponse(got, c.wantCache, t)
 	}
 }
+
+func TestDiscoveryCacheSize(t *testing.T) {
+	c := newDiscoveryCache(true, 0, 0)
+	c.updateCachedDiscoveryResponse("a", c.generation, []byte("a"))
+	c.updateCachedDiscoveryResponse("b", c.generation, []byte("b"))
+	if got := c.size(); got.Warm != 2 || got.Total != 2 {
+		t.Errorf("size() after populating got %+v, want warm=2 total=2", got)
+	}
+	c.clear()
+	if got := c.size(); got.Warm != 0 || got.Total != 2 {
+		t.Errorf("size() after clear got %+v, want warm=0 total=2", got)
+	}
+	c.updateCachedDiscoveryResponse("a", c.generation, []byte("a"))
+	if got := c.size(); got.Warm != 1 || got.Total != 2 {
+		t.Errorf("size() after repopulating got %+v, want warm=1 total=2", got)
+	}
+}
+
+func TestDiscoveryCacheClearedBeforeUpdate(t *testing.T) {
+	c := newDiscoveryCache(true, 0, 0)
+	_, _, generation, cached := c.cachedDiscoveryResponse("a")
+	if cached {
+		t.Fatal("empty cache returned a response")
+	}
+	// a clear while the response is computed discards it
+	c.clear()
+	c.updateCachedDiscoveryResponse("a", generation, []byte("stale"))
+	if out, _, _, cached := c.cachedDiscoveryResponse("a"); cached {
+		t.Errorf("got cached response %q computed before the clear", out)
+	}
+	if got := c.stats()["a"]; got.Hit != 0 || got.Miss != 1 {
+		t.Errorf("stats got %+v, want hit=0 miss=1", got)
+	}
+}
+
+func TestDiscoveryCacheTTL(t *testing.T) {
+	now := time.Now()
+	c := newDiscoveryCache(true, time.Minute, 0)
+	c.now = func() time.Time { return now }
+	c.updateCachedDiscoveryResponse("a", c.generation, []byte("a"))
+	now = now.Add(30 * time.Second)
+	c.updateCachedDiscoveryResponse("b", c.generation, []byte("b"))
+
+	now = now.Add(45 * time.Second)
+	if _, _, _, cached := c.cachedDiscoveryResponse("a"); cached {
+		t.Error("got cached response for expired entry a")
+	}
+	if _, _, _, cached := c.cachedDiscoveryResponse("b"); !cached {
+		t.Error("missing cached response for entry b")
+	}
+
+	c.purge()
+	if _, ok := c.cache["a"]; ok {
+		t.Error("expired entry a should be purged")
+	}
+	if got := c.size(); got.Warm != 1 || got.Total != 1 {
+		t.Errorf("size() after purge got %+v, want warm=1 total=1", got)
+	}
+
+	// cleared entries are purged once they expire
+	c.clear()
+	now = now.Add(time.Minute)
+	c.purge()
+	if got := c.size(); got.Total != 0 {
+		t.Errorf("size() after purging cleared entries got %+v, want total=0", got)
+	}
+
+	// without a TTL entries never expire
+	c = newDiscoveryCache(true, 0, 0)
+	c.now = func() time.Time { return now }
+	c.updateCachedDiscoveryResponse("a", c.generation, []byte("a"))
+	now = now.Add(24 * time.Hour)
+	c.purge()
+	if _, _, _, cached := c.cachedDiscoveryResponse("a"); !cached {
+		t.Error("missing cached response without a TTL")
+	}
+}
+
+func TestDiscoveryCacheMaxEntries(t *testing.T) {
+	const max = 3
+	c := newDiscoveryCache(true, 0, max)
+	for i := 0; i < max; i++ {
+		key := fmt.Sprintf("key%d", i)
+		c.updateCachedDiscoveryResponse(key, c.generation, []byte(key))
+	}
+	// key0 is used again, so key1 is the least recently used entry
+	if _, _, _, cached := c.cachedDiscoveryResponse("key0"); !cached {
+		t.Fatal("missing cached response for key0")
+	}
+	for i := max; i < max+2; i++ {
+		key := fmt.Sprintf("key%d", i)
+		c.updateCachedDiscoveryResponse(key, c.generation, []byte(key))
+	}
+
+	if got := c.size(); got.Total != max {
+		t.Errorf("size() got %+v, want total=%d", got, max)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if _, ok := c.cache[key]; ok {
+			t.Errorf("least recently used entry %s should be evicted", key)
+		}
+	}
+	for _, key := range []string{"key0", "key3", "key4"} {
+		if out, _, _, cached := c.cachedDiscoveryResponse(key); !cached || string(out) != key {
+			t.Errorf("got cached response %q for %s, want %q", out, key, key)
+		}
+	}
+	// the stats of evicted entries are dropped with them
+	stats := c.stats()
+	if got := stats["key0"]; got == nil || got.Hit != 2 || got.Miss != 1 {
+		t.Errorf("stats for key0 got %+v, want hit=2 miss=1", got)
+	}
+	if got := stats["key4"]; got == nil || got.Hit != 1 || got.Miss != 1 {
+		t.Errorf("stats for key4 got %+v, want hit=1 miss=1", got)
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if got, ok := stats[key]; ok {
+			t.Errorf("stats for evicted entry %s got %+v, want none", key, got)
+		}
+	}
+	if len(stats) != max {
+		t.Errorf("got stats for %d entries, want %d", len(stats), max)
+	}
+
+	// an evicted key starts over as a miss
+	c.updateCachedDiscoveryResponse("key1", c.generation, []byte("key1"))
+	if got := c.stats()["key1"]; got == nil || got.Hit != 0 || got.Miss != 1 {
+		t.Errorf("stats for re-added key1 got %+v, want hit=0 miss=1", got)
+	}
+	if c.recency.Len() != len(c.cache) {
+		t.Errorf("got %d keys in the recency list for %d entries", c.recency.Len(), len(c.cache))
+	}
+}
+
+func TestDiscoveryServiceStop(t *testing.T) {
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:      mock.Discovery,
+		Controller:    &mockController{},
+		Config:        mock.MakeRegistry(),
+		Mesh:          &DefaultMeshConfig,
+		EnableCaching: true,
+		CacheTTL:      time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+	url := "/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	_ = makeDiscoveryRequest(ds, "GET", url, t)
+	time.Sleep(10 * time.Millisecond)
+	ds.Stop()
+	// stopping twice is safe
+	ds.Stop()
+}
+
+func TestDiscoveryCacheConcurrentClear(t *testing.T) {
+	c := newDiscoveryCache(true, 0, 0)
+	const readers, reads = 8, 500
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				c.clear()
+			}
+		}
+	}()
+	for i := 0; i < readers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < reads; j++ {
+				out, _, generation, cached := c.cachedDiscoveryResponse("key")
+				if !cached {
+					c.updateCachedDiscoveryResponse("key", generation, []byte("data"))
+				} else if string(out) != "data" {
+					t.Errorf("got cached response %q, want %q", out, "data")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+
+	// every read is counted exactly once, as a hit or a miss
+	if got := c.stats()["key"]; got.Hit+got.Miss != readers*reads {
+		t.Errorf("stats got %+v, want hit+miss=%d", got, readers*reads)
+	}
+}
+
+func TestDiscoveryUnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "discovery")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+	socket := filepath.Join(dir, "sds.sock")
+
+	ds, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   mock.Discovery,
+		Controller: &mockController{},
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+		UnixSocket: socket,
+	})
+	if err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+	go ds.Run()
+
+	client := &http.Client{Transport: &http.Transport{
+		Dial: func(_, _ string) (net.Conn, error) {
+			return net.Dial("unix", socket)
+		},
+	}}
+	url := "http://unix/v1/registration/" + mock.HelloService.Key(mock.HelloService.Ports[0], nil)
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(body, "testdata/sds.json", t)
+
+	if err = ds.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket file %s should be removed on close: %v", socket, err)
+	}
+}
+
+func TestBuildEndpointMetadata(t *testing.T) {
+	instance := mock.MakeInstance(mock.HelloService, mock.HelloService.Ports[0], 0)
+	if got := buildEndpointMetadata(instance); got == nil || got.FilterMetadata[LbMetadataNamespace]["version"] != "v0" {
+		t.Errorf("buildEndpointMetadata(%v) => got %v, want version tag", instance, got)
+	}
+	instance.Tags = model.Tags{"bad key": "v0"}
+	if got := buildEndpointMetadata(instance); got != nil {
+		t.Errorf("buildEndpointMetadata(%v) => got %v, want no metadata for malformed tags", instance, got)
+	}
+}
+
+func TestServiceDiscoveryWeighted(t *testing.T) {
+	port := mock.HelloService.Ports[0]
+	instances := []*model.ServiceInstance{
+		mock.MakeInstance(mock.HelloService, port, 0),
+		mock.MakeInstance(mock.HelloService, port, 1),
+		mock.MakeInstance(mock.HelloService, port, 2),
+	}
+	instances[0].Tags[WeightTag] = "90"
+	instances[1].Tags[WeightTag] = "10"
+	body, err := json.MarshalIndent(buildHosts(instances), " ", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(body, "testdata/sds-weighted.json", t)
+}
+
+// weightedDiscovery tags the v0 instances of the mock services with
+// an endpoint weight
+type weightedDiscovery struct {
+	model.ServiceDiscovery
+}
+
+func (d weightedDiscovery) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	out := d.ServiceDiscovery.Instances(hostname, ports, tags)
+	for _, instance := range out {
+		if instance.Tags["version"] == "v0" {
+			instance.Tags[WeightTag] = "80"
+		}
+	}
+	return out
+}
+
+func TestWeightedRouteOverlappingSubsets(t *testing.T) {
+	// the catch-all subset overlaps the v0 subset
+	rule := &proxyconfig.RouteRule{
+		Destination: mock.HelloService.Hostname,
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v0"}, Weight: 90},
+			{Weight: 10},
+		},
+	}
+	port := mock.HelloService.Ports[0]
+	route, _ := buildHTTPRoute(rule, port)
+	discovery := weightedDiscovery{mock.Discovery}
+	out := struct {
+		Route *HTTPRoute       `json:"route"`
+		Hosts map[string]hosts `json:"hosts"`
+	}{Route: route, Hosts: make(map[string]hosts)}
+	for _, cluster := range route.clusters {
+		instances := discovery.Instances(cluster.hostname, []string{cluster.port.Name}, model.TagsList{cluster.tags})
+		out.Hosts[cluster.ServiceName] = buildHosts(instances)
+	}
+	body, err := json.MarshalIndent(out, " ", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(body, "testdata/weighted-overlap.json", t)
+
+	if got := endpointWeightedRoutes(rule, discovery); len(got) != 2 {
+		t.Errorf("endpointWeightedRoutes() with weighted endpoints => got %v, want both routes", got)
+	}
+	if got := endpointWeightedRoutes(rule, mock.Discovery); len(got) != 0 {
+		t.Errorf("endpointWeightedRoutes() without weighted endpoints => got %v, want none", got)
+	}
+}
+
+func TestEndpointWeight(t *testing.T) {
+	cases := []struct {
+		tag  string
+		want int
+	}{
+		{tag: "", want: 0},
+		{tag: "1", want: 1},
+		{tag: "100", want: 100},
+		{tag: "0", want: 0},
+		{tag: "101", want: 0},
+		{tag: "-5", want: 0},
+		{tag: "heavy", want: 0},
+	}
+	for _, c := range cases {
+		instance := mock.MakeInstance(mock.HelloService, mock.HelloService.Ports[0], 0)
+		if c.tag != "" {
+			instance.Tags[WeightTag] = c.tag
+		}
+		if got := endpointWeight(instance); got != c.want {
+			t.Errorf("endpointWeight(%q) => got %d, want %d", c.tag, got, c.want)
+		}
+	}
+}
+
+func TestServiceDiscoveryV2SNI(t *testing.T) {
+	port := mock.HelloService.Ports[0]
+	instances := []*model.ServiceInstance{
+		mock.MakeInstance(mock.HelloService, port, 0),
+		mock.MakeInstance(mock.HelloService, port, 1),
+	}
+	instances[0].Endpoint.SNI = "tenant-a.example.com"
+	body, err := json.MarshalIndent(buildLocalityLbEndpoints(mock.HelloService.Key(port, nil), instances, nil), " ", " ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compareResponse(body, "testdata/sds-v2-sni.json", t)
+}
+
+// handlerController records the registered handlers for invoking them in tests
+type handlerController struct {
+	mockController
+	configHandlers   []func(model.Key, proto.Message, model.Event)
+	serviceHandlers  []func(*model.Service, model.Event)
+	instanceHandlers []func(*model.ServiceInstance, model.Event)
+}
+
+func (c *handlerController) AppendConfigHandler(_ string, f func(model.Key, proto.Message, model.Event)) error {
+	c.configHandlers = append(c.configHandlers, f)
+	return nil
+}
+func (c *handlerController) AppendServiceHandler(f func(*model.Service, model.Event)) error {
+	c.serviceHandlers = append(c.serviceHandlers, f)
+	return nil
+}
+func (c *handlerController) AppendInstanceHandler(f func(*model.ServiceInstance, model.Event)) error {
+	c.instanceHandlers = append(c.instanceHandlers, f)
+	return nil
+}
+
+// lookupDiscovery records the hostnames of instance lookups
+type lookupDiscovery struct {
+	model.ServiceDiscovery
+	hostnames *[]string
+}
+
+func (d lookupDiscovery) Instances(hostname string, ports []string, tags model.TagsList) []*model.ServiceInstance {
+	*d.hostnames = append(*d.hostnames, hostname)
+	return d.ServiceDiscovery.Instances(hostname, ports, tags)
+}
+
+func TestDestinationPolicyHandlerChecksEndpoints(t *testing.T) {
+	ctl := &handlerController{}
+	var hostnames []string
+	if _, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   lookupDiscovery{ServiceDiscovery: mock.Discovery, hostnames: &hostnames},
+		Controller: ctl,
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+	}); err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+
+	// the route rule handler is registered first
+	handler := ctl.configHandlers[1]
+	key := model.Key{Kind: model.DestinationPolicy, Name: "policy", Namespace: "default"}
+	policy := &proxyconfig.DestinationPolicy{
+		Destination: mock.HelloService.Hostname,
+		Tags:        map[string]string{"version": "v9"},
+	}
+	handler(key, policy, model.EventDelete)
+	if len(hostnames) != 0 {
+		t.Errorf("deleted destination policy => got endpoint lookups %v, want none", hostnames)
+	}
+	handler(key, policy, model.EventAdd)
+	if len(hostnames) != 1 || hostnames[0] != mock.HelloService.Hostname {
+		t.Errorf("added destination policy => got endpoint lookups %v, want %s", hostnames, mock.HelloService.Hostname)
+	}
+}
+
+func TestRouteRuleHandlerChecksChangedRule(t *testing.T) {
+	ctl := &handlerController{}
+	var hostnames []string
+	if _, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   lookupDiscovery{ServiceDiscovery: mock.Discovery, hostnames: &hostnames},
+		Controller: ctl,
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+	}); err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+
+	handler := ctl.configHandlers[0]
+	key := model.Key{Kind: model.RouteRule, Name: "split", Namespace: "default"}
+	rule := &proxyconfig.RouteRule{
+		Destination: mock.HelloService.Hostname,
+		Route: []*proxyconfig.DestinationWeight{
+			{Tags: map[string]string{"version": "v0"}, Weight: 50},
+			{Tags: map[string]string{"version": "v1"}, Weight: 50},
+		},
+	}
+	handler(key, rule, model.EventDelete)
+	if len(hostnames) != 0 {
+		t.Errorf("deleted route rule => got endpoint lookups %v, want none", hostnames)
+	}
+	handler(key, rule, model.EventAdd)
+	if len(hostnames) != len(rule.Route) {
+		t.Errorf("added route rule => got endpoint lookups %v, want one per route of the rule", hostnames)
+	}
+}
+
+func TestNewRuleFindings(t *testing.T) {
+	ds := &DiscoveryService{}
+	if got := ds.newRuleFindings("default", []string{"a", "b"}); len(got) != 2 {
+		t.Errorf("newRuleFindings() on first report => got %v, want both", got)
+	}
+	if got := ds.newRuleFindings("default", []string{"a", "b", "c"}); len(got) != 1 || got[0] != "c" {
+		t.Errorf("newRuleFindings() on repeated report => got %v, want only c", got)
+	}
+	if got := ds.newRuleFindings("other", []string{"a"}); len(got) != 1 {
+		t.Errorf("newRuleFindings() in another namespace => got %v, want a", got)
+	}
+	ds.newRuleFindings("default", nil)
+	if got := ds.newRuleFindings("default", []string{"a"}); len(got) != 1 {
+		t.Errorf("newRuleFindings() after the finding cleared => got %v, want a again", got)
+	}
+}
+
+func TestDiscoveryAudit(t *testing.T) {
+	ctl := &handlerController{}
+	var events []AuditEvent
+	if _, err := NewDiscoveryService(DiscoveryServiceOptions{
+		Services:   mock.Discovery,
+		Controller: ctl,
+		Config:     mock.MakeRegistry(),
+		Mesh:       &DefaultMeshConfig,
+		Audit:      func(e AuditEvent) { events = append(events, e) },
+	}); err != nil {
+		t.Fatalf("NewDiscoveryService failed: %v", err)
+	}
+
+	key := model.Key{Kind: model.RouteRule, Name: "example", Namespace: "default"}
+	ctl.configHandlers[0](key, &proxyconfig.RouteRule{}, model.EventUpdate)
+	ctl.serviceHandlers[0](mock.HelloService, model.EventAdd)
+	ctl.instanceHandlers[0](mock.MakeInstance(mock.HelloService, mock.HelloService.Ports[0], 0), model.EventDelete)
+	ctl.instanceHandlers[0](&model.ServiceInstance{
+		Endpoint: model.NetworkEndpoint{Address: "10.1.1.0", Port: 80},
+	}, model.EventDelete)
+
+	want := []AuditEvent{
+		{Event: model.EventUpdate, Kind: model.RouteRule, Key: key.String()},
+		{Event: model.EventAdd, Kind: "service", Key: mock.HelloService.Hostname},
+		{Event: model.EventDelete, Kind: "instance",
+			Key: "hello.default.svc.cluster.local|http|version=v0 10.1.1.0:80"},
+		{Event: model.EventDelete, Kind: "instance", Key: "10.1.1.0:80"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d audit events, want %d: %v", len(events), len(want), events)
+	}
+	for i, e := range events {
+		if e.Time.IsZero() {
+			t.Errorf("audit event %v is missing a timestamp", e)
+		}
+		e.Time = time.Time{}
+		if e != want[i] {
+			t.Errorf("got audit event %v, want %v", e, want[i])
+		}
+	}
+}
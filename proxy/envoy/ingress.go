@@ -35,27 +35,33 @@ type ingressWatcher struct {
 	agent   proxy.Agent
 	ctl     model.Controller
 	context *IngressConfig
+	// events signals a pending reload to the Run loop
+	events chan struct{}
 }
 
-// NewIngressWatcher creates a new ingress watcher instance with an agent
-func NewIngressWatcher(ctl model.Controller, context *IngressConfig) (Watcher, error) {
-	agent := proxy.NewAgent(runEnvoy(context.Mesh, "ingress"), cleanupEnvoy(context.Mesh), 10, 100*time.Millisecond)
+// NewIngressWatcher creates a new ingress watcher instance with an agent.
+// The static option does not apply to ingress.
+func NewIngressWatcher(ctl model.Controller, context *IngressConfig, options WatcherOptions) (Watcher, error) {
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
 
 	out := &ingressWatcher{
-		agent:   agent,
+		agent:   newAgent(context.Mesh, "ingress", options),
 		ctl:     ctl,
 		context: context,
+		events:  make(chan struct{}, 1),
 	}
 
 	if err := ctl.AppendConfigHandler(model.IngressRule, func(model.Key, proto.Message, model.Event) {
-		out.reload()
+		scheduleReload(out.events)
 	}); err != nil {
 		return nil, err
 	}
 
 	// ingress rule listing depends on the service declaration being up to date
 	if err := ctl.AppendServiceHandler(func(*model.Service, model.Event) {
-		out.reload()
+		scheduleReload(out.events)
 	}); err != nil {
 		return nil, err
 	}
@@ -72,12 +78,12 @@ func (w *ingressWatcher) Run(stop <-chan struct{}) {
 
 	// Initialize envoy according to the current model state,
 	// instead of waiting for the first event to arrive.
-	// Note that this is currently done synchronously (blocking),
+	// The initial config is generated once the controller has
+	// populated the registry to avoid pushing an empty config.
+	// Reloads are linearized by a single goroutine reloader
 	// to avoid racing with controller events lurking around the corner.
-	// This can be improved once we switch to a mechanism where reloads
-	// are linearized (e.g., by a single goroutine reloader).
-	w.reload()
-	w.ctl.Run(stop)
+	go w.ctl.Run(stop)
+	runReloads(w.ctl, w.events, w.reload, stop)
 }
 
 // IngressConfig defines information for ingress
@@ -90,10 +96,25 @@ type IngressConfig struct {
 	Secrets   model.SecretRegistry
 	Registry  *model.IstioRegistry
 	Mesh      *config.ProxyMeshConfig
+	// Discovery is optional and used to check ingress rules against route rules
+	Discovery model.ServiceDiscovery
 }
 
 func generateIngress(conf *IngressConfig) *Config {
 	rules := conf.Registry.IngressRules(conf.Namespace)
+	if err := model.ValidateIngressRuleConflicts(rules); err != nil {
+		glog.Warningf("Conflicting ingress rules: %v", err)
+	}
+	if conf.Discovery != nil {
+		port := 80
+		if conf.Secret != "" {
+			port = 443
+		}
+		if err := model.ValidateIngressRouteCollisions(rules, conf.Registry.RouteRules(conf.Namespace),
+			port, conf.Discovery); err != nil {
+			glog.Warningf("Ingress rules collide with route rules: %v", err)
+		}
+	}
 
 	// Phase 1: group rules by host
 	rulesByHost := make(map[string][]*config.RouteRule, len(rules))
@@ -2,6 +2,7 @@ package envoy
 
 import (
 	"testing"
+	"time"
 
 	"istio.io/manager/model"
 	"istio.io/manager/test/mock"
@@ -77,3 +78,35 @@ func TestIngressRoutesSSL(t *testing.T) {
 	util.CompareFile(ingressCertFile, ingressCert, t)
 	util.CompareFile(ingressKeyFile, ingressKey, t)
 }
+
+func TestIngressWatcherInitialReload(t *testing.T) {
+	agent := &recordingAgent{configs: make(chan interface{}, 1)}
+	ctl := &readyController{ready: make(chan struct{})}
+	r := mock.MakeRegistry()
+	w := &ingressWatcher{
+		agent:   agent,
+		ctl:     ctl,
+		context: &IngressConfig{Registry: r, Mesh: &DefaultMeshConfig},
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+
+	select {
+	case <-agent.configs:
+		t.Fatal("initial reload should wait for the controller to be ready")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	// the controller syncs the ingress rules before it becomes ready
+	addIngressRoute(r, t)
+	close(ctl.ready)
+	select {
+	case config := <-agent.configs:
+		if envoyConfig := config.(*Config); len(envoyConfig.ClusterManager.Clusters) == 0 {
+			t.Errorf("initial reload should carry the ingress rules, got %#v", envoyConfig)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("initial reload did not happen after the controller became ready")
+	}
+}
@@ -393,6 +393,7 @@ type SSLContextWithSAN struct {
 	PrivateKeyFile       string   `json:"private_key_file"`
 	CaCertFile           string   `json:"ca_cert_file,omitempty"`
 	VerifySubjectAltName []string `json:"verify_subject_alt_name"`
+	ALPNProtocols        string   `json:"alpn_protocols,omitempty"`
 }
 
 // Admin definition
@@ -424,6 +425,8 @@ type Cluster struct {
 	hostname string
 	port     *model.Port
 	tags     model.Tags
+	// external is set for clusters resolving an external service by DNS
+	external bool
 }
 
 // CircuitBreaker definition
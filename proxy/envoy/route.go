@@ -48,13 +48,19 @@ func buildListenerSSLContext(certsDir string) *SSLContext {
 }
 
 // buildClusterSSLContext returns an SSLContextWithSAN struct with VerifySubjectAltName.
-// The list of service accounts may be empty but not nil.
-func buildClusterSSLContext(certsDir string, serviceAccounts []string) *SSLContextWithSAN {
+// The list of service accounts may be empty but not nil. ALPN advertises HTTP/2
+// for HTTP/2 clusters and HTTP/1.1 otherwise.
+func buildClusterSSLContext(certsDir string, serviceAccounts []string, cluster *Cluster) *SSLContextWithSAN {
+	alpn := "http/1.1"
+	if cluster.Features == "http2" {
+		alpn = "h2"
+	}
 	return &SSLContextWithSAN{
 		CertChainFile:        certsDir + "/cert-chain.pem",
 		PrivateKeyFile:       certsDir + "/key.pem",
 		CaCertFile:           certsDir + "/root-cert.pem",
 		VerifySubjectAltName: serviceAccounts,
+		ALPNProtocols:        alpn,
 	}
 }
 
@@ -98,6 +104,24 @@ func buildOutboundCluster(hostname string, port *model.Port, tags model.Tags) *C
 	return cluster
 }
 
+// setExternalHosts makes the outbound clusters of external services resolve
+// the external name by DNS, since external services have no instances in SDS
+func (clusters Clusters) setExternalHosts(discovery model.ServiceDiscovery) {
+	for _, cluster := range clusters {
+		if cluster.port == nil {
+			continue
+		}
+		service, ok := discovery.GetService(cluster.hostname)
+		if !ok || service.ExternalName == "" {
+			continue
+		}
+		cluster.ServiceName = ""
+		cluster.Type = "strict_dns"
+		cluster.Hosts = []Host{{URL: fmt.Sprintf("tcp://%s:%d", service.ExternalName, cluster.port.Port)}}
+		cluster.external = true
+	}
+}
+
 // buildHTTPRoute translates a route rule to an Envoy route
 func buildHTTPRoute(rule *proxyconfig.RouteRule, port *model.Port) (*HTTPRoute, bool) {
 	route := &HTTPRoute{
@@ -17,6 +17,8 @@ package envoy
 import (
 	"strings"
 	"testing"
+
+	"istio.io/manager/model"
 )
 
 var (
@@ -49,3 +51,21 @@ func TestSharedHost(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildClusterSSLContextALPN(t *testing.T) {
+	cases := []struct {
+		protocol model.Protocol
+		alpn     string
+	}{
+		{model.ProtocolHTTP, "http/1.1"},
+		{model.ProtocolHTTP2, "h2"},
+		{model.ProtocolGRPC, "h2"},
+	}
+	for _, c := range cases {
+		port := &model.Port{Name: "port", Port: 80, Protocol: c.protocol}
+		cluster := buildOutboundCluster("hello.default.svc.cluster.local", port, nil)
+		if got := buildClusterSSLContext("/etc/certs", []string{}, cluster).ALPNProtocols; got != c.alpn {
+			t.Errorf("ALPN for %s => got %q, want %q", c.protocol, got, c.alpn)
+		}
+	}
+}
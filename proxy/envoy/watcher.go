@@ -15,13 +15,19 @@
 package envoy
 
 import (
+	"expvar"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
+	"github.com/hashicorp/errwrap"
+	"github.com/hashicorp/go-multierror"
 
 	proxyconfig "istio.io/api/proxy/v1/config"
 	"istio.io/manager/model"
@@ -49,48 +55,109 @@ type watcher struct {
 	agent   proxy.Agent
 	context *ProxyContext
 	ctl     model.Controller
+	static  bool
+	// events signals a pending reload to the Run loop
+	events chan struct{}
 }
 
-// NewWatcher creates a new watcher instance with an agent
+// Default proxy agent restart settings
+const (
+	// DefaultMaxRetries is the number of attempts to restart the proxy with a new configuration
+	DefaultMaxRetries = 10
+
+	// DefaultRetryInterval is the delay before the first restart attempt, doubled on each retry
+	DefaultRetryInterval = 100 * time.Millisecond
+)
+
+// WatcherOptions configures the proxy agent and the Envoy process of a watcher
+type WatcherOptions struct {
+	// MaxRetries is the number of attempts to restart the proxy with a new configuration
+	MaxRetries int
+
+	// RetryInterval is the delay before the first restart attempt, doubled on each retry
+	RetryInterval time.Duration
+
+	// DryRun writes the generated configuration to stdout instead of starting Envoy
+	DryRun bool
+
+	// Static embeds all clusters and routes in the sidecar configuration instead of
+	// relying on the discovery service
+	Static bool
+
+	// LogFormat is the Envoy log format, or the Envoy default format if empty
+	LogFormat string
+
+	// LogPath is the file Envoy writes its logs to, or stderr if empty
+	LogPath string
+
+	// Concurrency is the number of Envoy worker threads, or one per hardware
+	// thread if zero
+	Concurrency int
+}
+
+// DefaultWatcherOptions restarts the proxy with the default settings
+var DefaultWatcherOptions = WatcherOptions{
+	MaxRetries:    DefaultMaxRetries,
+	RetryInterval: DefaultRetryInterval,
+}
+
+// Validate checks the watcher options
+func (o WatcherOptions) Validate() (errs error) {
+	if o.MaxRetries <= 0 {
+		errs = multierror.Append(errs, fmt.Errorf("max retries must be positive: %d", o.MaxRetries))
+	}
+	if o.RetryInterval <= 0 {
+		errs = multierror.Append(errs, fmt.Errorf("retry interval must be positive: %v", o.RetryInterval))
+	}
+	if err := ValidateLogOptions(o.LogFormat, o.LogPath); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	if err := ValidateConcurrency(o.Concurrency); err != nil {
+		errs = multierror.Append(errs, err)
+	}
+	return
+}
+
+// NewWatcher creates a new watcher instance with an agent. The agent attempts to restart
+// the proxy up to the maximum number of retries in the options, with an exponential back-off.
 func NewWatcher(discovery model.ServiceDiscovery, ctl model.Controller,
-	registry *model.IstioRegistry, mesh *proxyconfig.ProxyMeshConfig, ipAddress string) (Watcher, error) {
+	registry *model.IstioRegistry, mesh *proxyconfig.ProxyMeshConfig, ipAddress string,
+	options WatcherOptions) (Watcher, error) {
 	glog.V(2).Infof("Local instance address: %s", ipAddress)
 
+	if err := options.Validate(); err != nil {
+		return nil, err
+	}
+	if err := model.ValidateProxyMeshConfig(mesh); err != nil {
+		return nil, err
+	}
+
 	// Use proxy node IP as the node name
 	// This parameter is used as the value for "service-node"
-	agent := proxy.NewAgent(runEnvoy(mesh, ipAddress), cleanupEnvoy(mesh), 10, 100*time.Millisecond)
-
 	out := &watcher{
-		agent: agent,
+		agent: newAgent(mesh, ipAddress, options),
 		context: &ProxyContext{
 			Discovery:  discovery,
 			Config:     registry,
 			MeshConfig: mesh,
 			IPAddress:  ipAddress,
 		},
-		ctl: ctl,
+		ctl:    ctl,
+		static: options.Static,
+		events: make(chan struct{}, 1),
 	}
 
-	// Initialize envoy according to the current model state,
-	// instead of waiting for the first event to arrive.
-	// Note that this is currently done synchronously (blocking),
-	// to avoid racing with controller events lurking around the corner.
-	// This can be improved once we switch to a mechanism where reloads
-	// are linearized (e.g., by a single goroutine reloader).
-	// TODO: this blocks
-	//	out.reload()
-
-	if err := ctl.AppendServiceHandler(func(*model.Service, model.Event) { out.reload() }); err != nil {
+	if err := ctl.AppendServiceHandler(func(*model.Service, model.Event) { scheduleReload(out.events) }); err != nil {
 		return nil, err
 	}
 
 	// TODO: restrict the notification callback to co-located instances (e.g. with the same IP)
 	// TODO: editing pod tags directly does not trigger instance handlers, we need to listen on pod resources.
-	if err := ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) { out.reload() }); err != nil {
+	if err := ctl.AppendInstanceHandler(func(*model.ServiceInstance, model.Event) { scheduleReload(out.events) }); err != nil {
 		return nil, err
 	}
 
-	handler := func(model.Key, proto.Message, model.Event) { out.reload() }
+	handler := func(model.Key, proto.Message, model.Event) { scheduleReload(out.events) }
 
 	if err := ctl.AppendConfigHandler(model.RouteRule, handler); err != nil {
 		return nil, err
@@ -106,15 +173,70 @@ func NewWatcher(discovery model.ServiceDiscovery, ctl model.Controller,
 func (w *watcher) Run(stop <-chan struct{}) {
 	// must start consumer before producer
 	go w.agent.Run(stop)
-	w.ctl.Run(stop)
+
+	// Initialize envoy according to the current model state,
+	// instead of waiting for the first event to arrive.
+	// The initial config is generated once the controller has
+	// populated the registry to avoid pushing an empty config.
+	// Reloads are linearized by a single goroutine reloader
+	// to avoid racing with controller events lurking around the corner.
+	go w.ctl.Run(stop)
+	runReloads(w.ctl, w.events, w.reload, stop)
+}
+
+// scheduleReload signals a pending reload without blocking the controller.
+// Pending signals coalesce since the config is generated from the latest cache view.
+func scheduleReload(events chan<- struct{}) {
+	select {
+	case events <- struct{}{}:
+	default:
+	}
+}
+
+// runReloads calls reload once the controller is ready and on every event signal,
+// until the stop channel is closed
+func runReloads(ctl model.Controller, events <-chan struct{}, reload func(), stop <-chan struct{}) {
+	ready := ctl.Ready()
+	for {
+		select {
+		case <-ready:
+			ready = nil
+			reload()
+		case <-events:
+			reload()
+		case <-stop:
+			return
+		}
+	}
 }
 
 func (w *watcher) reload() {
 	// TODO
 	// even though the function is called on every modification event,
 	// the actual config is generated from the latest cache view
-	config := Generate(w.context)
+	start := time.Now()
+	var config *Config
+	if w.static {
+		config = GenerateStatic(w.context)
+	} else {
+		config = Generate(w.context)
+	}
 	w.agent.ScheduleConfigUpdate(config)
+	recordGenerate(time.Since(start))
+}
+
+// watcherMetrics exposes proxy config reload statistics via expvar:
+// - reloads: number of generated proxy configurations
+// - last_generate_ms: time to generate and schedule the last configuration
+//
+// The proxy restart itself is tracked by the agent metrics.
+var watcherMetrics = expvar.NewMap("envoy_watcher")
+
+func recordGenerate(duration time.Duration) {
+	watcherMetrics.Add("reloads", 1)
+	last := new(expvar.Float)
+	last.Set(duration.Seconds() * 1000)
+	watcherMetrics.Set("last_generate_ms", last)
 }
 
 const (
@@ -128,11 +250,42 @@ const (
 	ConfigPath = "/etc/envoy"
 )
 
+// ValidateLogOptions checks the Envoy log format and path: the format is a
+// single line and the path, if set, is absolute
+func ValidateLogOptions(format, path string) error {
+	if strings.ContainsAny(format, "\r\n") {
+		return fmt.Errorf("Envoy log format %q must be a single line", format)
+	}
+	if path != "" && !filepath.IsAbs(path) {
+		return fmt.Errorf("Envoy log path %q must be absolute", path)
+	}
+	return nil
+}
+
+// ValidateConcurrency checks that the number of Envoy worker threads is not
+// negative, where zero selects one per hardware thread
+func ValidateConcurrency(concurrency int) error {
+	if concurrency < 0 {
+		return fmt.Errorf("Envoy concurrency must not be negative: %d", concurrency)
+	}
+	return nil
+}
+
 func configFile(config string, epoch int) string {
 	return fmt.Sprintf(EpochFileTemplate, config, epoch)
 }
 
-func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, ip string) func(interface{}, int) error {
+// newAgent creates a proxy agent that runs Envoy, or writes the configuration to
+// stdout in dry run mode
+func newAgent(mesh *proxyconfig.ProxyMeshConfig, ip string, options WatcherOptions) proxy.Agent {
+	run, cleanup := runEnvoy(mesh, ip, options), cleanupEnvoy(mesh)
+	if options.DryRun {
+		run, cleanup = dryRunEnvoy(os.Stdout), func(int) {}
+	}
+	return proxy.NewAgent(run, cleanup, options.MaxRetries, options.RetryInterval)
+}
+
+func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, ip string, options WatcherOptions) func(interface{}, int) error {
 	return func(config interface{}, epoch int) error {
 		envoyConfig, ok := config.(*Config)
 		if !ok {
@@ -140,27 +293,13 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, ip string) func(interface{}, in
 		}
 
 		// attempt to write file
-		fname := configFile(ConfigPath, epoch)
-		if err := envoyConfig.WriteFile(fname); err != nil {
+		fname, err := writeEpochConfig(envoyConfig, ConfigPath, epoch)
+		if err != nil {
 			return err
 		}
 
 		// spin up a new Envoy process
-		args := []string{"-c", fname,
-			"--restart-epoch", fmt.Sprint(epoch),
-			"--drain-time-s", fmt.Sprint(int(convertDuration(mesh.DrainDuration) / time.Second)),
-			"--parent-shutdown-time-s", fmt.Sprint(int(convertDuration(mesh.ParentShutdownDuration) / time.Second)),
-			"--service-cluster", mesh.IstioServiceCluster,
-			"--service-node", ip,
-		}
-
-		// inject tracing flag for higher levels
-		if glog.V(4) {
-			args = append(args, "-l", "trace")
-		} else if glog.V(3) {
-			args = append(args, "-l", "debug")
-		}
-
+		args := envoyArgs(fname, epoch, mesh, ip, options)
 		glog.V(2).Infof("Envoy command: %v", args)
 
 		/* #nosec */
@@ -172,6 +311,67 @@ func runEnvoy(mesh *proxyconfig.ProxyMeshConfig, ip string) func(interface{}, in
 	}
 }
 
+// envoyArgs builds the Envoy command line for the config file of an epoch
+func envoyArgs(fname string, epoch int, mesh *proxyconfig.ProxyMeshConfig, ip string,
+	options WatcherOptions) []string {
+	args := []string{"-c", fname,
+		"--restart-epoch", fmt.Sprint(epoch),
+		"--drain-time-s", fmt.Sprint(int(convertDuration(mesh.DrainDuration) / time.Second)),
+		"--parent-shutdown-time-s", fmt.Sprint(int(convertDuration(mesh.ParentShutdownDuration) / time.Second)),
+		"--service-cluster", mesh.IstioServiceCluster,
+		"--service-node", ip,
+	}
+
+	// inject tracing flag for higher levels
+	if glog.V(4) {
+		args = append(args, "-l", "trace")
+	} else if glog.V(3) {
+		args = append(args, "-l", "debug")
+	}
+
+	if options.LogFormat != "" {
+		args = append(args, "--log-format", options.LogFormat)
+	}
+	if options.LogPath != "" {
+		args = append(args, "--log-path", options.LogPath)
+	}
+	if options.Concurrency > 0 {
+		args = append(args, "--concurrency", fmt.Sprint(options.Concurrency))
+	}
+	return args
+}
+
+// dryRunEnvoy writes the configuration for each epoch to the writer instead of
+// starting an Envoy process
+func dryRunEnvoy(w io.Writer) func(interface{}, int) error {
+	return func(config interface{}, _ int) error {
+		envoyConfig, ok := config.(*Config)
+		if !ok {
+			return fmt.Errorf("Unexpected config type: %#v", config)
+		}
+		if err := envoyConfig.Write(w); err != nil {
+			return err
+		}
+		_, err := fmt.Fprintln(w)
+		return err
+	}
+}
+
+// writeEpochConfig writes the config for an epoch to the config directory,
+// creating the directory if necessary
+func writeEpochConfig(config *Config, dir string, epoch int) (string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("could not create Envoy config directory %q "+
+			"(check that the proxy has write access to it): {{err}}", dir), err)
+	}
+	fname := configFile(dir, epoch)
+	if err := config.WriteFile(fname); err != nil {
+		return "", errwrap.Wrapf(fmt.Sprintf("could not write Envoy config %q "+
+			"(check that the proxy has write access to %q): {{err}}", fname, dir), err)
+	}
+	return fname, nil
+}
+
 func cleanupEnvoy(mesh *proxyconfig.ProxyMeshConfig) func(int) {
 	return func(epoch int) {
 		path := configFile(ConfigPath, epoch)
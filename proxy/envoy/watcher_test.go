@@ -0,0 +1,279 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package envoy
+
+import (
+	"bytes"
+	"encoding/json"
+	"expvar"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/duration"
+
+	"istio.io/manager/test/mock"
+)
+
+func TestWriteEpochConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "envoy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	// the config directory is created if missing
+	configDir := filepath.Join(dir, "etc", "envoy")
+	fname, err := writeEpochConfig(&Config{}, configDir, 1)
+	if err != nil {
+		t.Fatalf("writeEpochConfig failed: %v", err)
+	}
+	if want := configFile(configDir, 1); fname != want {
+		t.Errorf("writeEpochConfig => got %q, want %q", fname, want)
+	}
+	if _, err = os.Stat(fname); err != nil {
+		t.Error(err)
+	}
+
+	// a directory that cannot be created reports the path
+	blocked := filepath.Join(fname, "envoy")
+	if _, err = writeEpochConfig(&Config{}, blocked, 1); err == nil {
+		t.Fatalf("writeEpochConfig should fail for %q", blocked)
+	} else if !strings.Contains(err.Error(), blocked) {
+		t.Errorf("writeEpochConfig error should name the directory %q: %v", blocked, err)
+	}
+}
+
+func TestDryRunEnvoy(t *testing.T) {
+	// an epoch that no other test starts, so no config file exists for it
+	epoch := 4242
+	var out bytes.Buffer
+	run := dryRunEnvoy(&out)
+	config := &Config{Admin: Admin{Address: "tcp://127.0.0.1:15000"}}
+	if err := run(config, epoch); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	var got Config
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("dry run output is not a config: %v", err)
+	}
+	if got.Admin.Address != config.Admin.Address {
+		t.Errorf("dry run => got admin address %q, want %q", got.Admin.Address, config.Admin.Address)
+	}
+	if _, err := os.Stat(configFile(ConfigPath, epoch)); !os.IsNotExist(err) {
+		t.Errorf("dry run should not write a config file: %v", err)
+	}
+
+	if err := run("garbage", epoch); err == nil {
+		t.Error("dry run should reject an unexpected config type")
+	}
+}
+
+func TestNewWatcherRetry(t *testing.T) {
+	registry := mock.MakeRegistry()
+	if _, err := NewWatcher(mock.Discovery, &mockController{}, registry, &DefaultMeshConfig, mock.HostInstanceV0,
+		DefaultWatcherOptions); err != nil {
+		t.Errorf("NewWatcher failed: %v", err)
+	}
+	options := DefaultWatcherOptions
+	options.MaxRetries = 0
+	if _, err := NewWatcher(mock.Discovery, &mockController{}, registry, &DefaultMeshConfig, mock.HostInstanceV0,
+		options); err == nil {
+		t.Error("NewWatcher should reject zero max retries")
+	}
+	options = DefaultWatcherOptions
+	options.RetryInterval = -time.Second
+	if _, err := NewWatcher(mock.Discovery, &mockController{}, registry, &DefaultMeshConfig, mock.HostInstanceV0,
+		options); err == nil {
+		t.Error("NewWatcher should reject a negative retry interval")
+	}
+	mesh := DefaultMeshConfig
+	mesh.ProxyAdminPort = mesh.ProxyListenPort
+	if _, err := NewWatcher(mock.Discovery, &mockController{}, registry, &mesh, mock.HostInstanceV0,
+		DefaultWatcherOptions); err == nil {
+		t.Error("NewWatcher should reject an admin port conflicting with the listen port")
+	}
+	mesh = DefaultMeshConfig
+	mesh.DrainDuration = &duration.Duration{Seconds: -1}
+	if _, err := NewWatcher(mock.Discovery, &mockController{}, registry, &mesh, mock.HostInstanceV0,
+		DefaultWatcherOptions); err == nil {
+		t.Error("NewWatcher should reject a negative drain duration")
+	}
+}
+
+func TestValidateLogOptions(t *testing.T) {
+	cases := []struct {
+		format string
+		path   string
+		valid  bool
+	}{
+		{valid: true},
+		{format: "[%Y-%m-%d %T.%e][%t][%l][%n] %v", path: "/var/log/envoy.log", valid: true},
+		{format: "line\nbreak"},
+		{path: "envoy.log"},
+	}
+	for _, c := range cases {
+		if err := ValidateLogOptions(c.format, c.path); (err == nil) != c.valid {
+			t.Errorf("ValidateLogOptions(%q, %q) => got valid=%v, want %v: %v",
+				c.format, c.path, err == nil, c.valid, err)
+		}
+	}
+}
+
+func TestEnvoyLogArgs(t *testing.T) {
+	args := strings.Join(envoyArgs("envoy.json", 0, &DefaultMeshConfig, mock.HostInstanceV0,
+		DefaultWatcherOptions), " ")
+	if strings.Contains(args, "--log-format") || strings.Contains(args, "--log-path") {
+		t.Errorf("envoyArgs() without log options => got %q, want the Envoy defaults", args)
+	}
+
+	options := DefaultWatcherOptions
+	options.LogFormat, options.LogPath = "%v", "/var/log/envoy.log"
+	args = strings.Join(envoyArgs("envoy.json", 0, &DefaultMeshConfig, mock.HostInstanceV0, options), " ")
+	for _, want := range []string{"--log-format %v", "--log-path /var/log/envoy.log"} {
+		if !strings.Contains(args, want) {
+			t.Errorf("envoyArgs() => got %q, want %q", args, want)
+		}
+	}
+}
+
+func TestEnvoyConcurrencyArgs(t *testing.T) {
+	args := strings.Join(envoyArgs("envoy.json", 0, &DefaultMeshConfig, mock.HostInstanceV0,
+		DefaultWatcherOptions), " ")
+	if strings.Contains(args, "--concurrency") {
+		t.Errorf("envoyArgs() without concurrency => got %q, want the Envoy default", args)
+	}
+
+	options := DefaultWatcherOptions
+	options.Concurrency = 4
+	args = strings.Join(envoyArgs("envoy.json", 0, &DefaultMeshConfig, mock.HostInstanceV0, options), " ")
+	if !strings.Contains(args, "--concurrency 4") {
+		t.Errorf("envoyArgs() => got %q, want %q", args, "--concurrency 4")
+	}
+
+	for _, c := range []struct {
+		concurrency int
+		valid       bool
+	}{{0, true}, {4, true}, {-1, false}} {
+		if err := ValidateConcurrency(c.concurrency); (err == nil) != c.valid {
+			t.Errorf("ValidateConcurrency(%d) => got valid=%v, want %v", c.concurrency, err == nil, c.valid)
+		}
+	}
+
+	options.Concurrency = -1
+	if _, err := NewWatcher(mock.Discovery, &mockController{}, mock.MakeRegistry(), &DefaultMeshConfig,
+		mock.HostInstanceV0, options); err == nil {
+		t.Error("NewWatcher should reject a negative concurrency")
+	}
+}
+
+// recordingAgent captures the scheduled configurations
+type recordingAgent struct {
+	configs chan interface{}
+}
+
+func (a *recordingAgent) ScheduleConfigUpdate(config interface{}) {
+	a.configs <- config
+}
+
+func (a *recordingAgent) Run(stop <-chan struct{}) {}
+
+// readyController becomes ready when signalled
+type readyController struct {
+	mockController
+	ready chan struct{}
+}
+
+func (c *readyController) Ready() <-chan struct{} {
+	return c.ready
+}
+
+func (c *readyController) Run(stop <-chan struct{}) {
+	<-stop
+}
+
+func TestWatcherInitialReload(t *testing.T) {
+	agent := &recordingAgent{configs: make(chan interface{}, 1)}
+	ctl := &readyController{ready: make(chan struct{})}
+	w := &watcher{
+		agent: agent,
+		ctl:   ctl,
+		context: &ProxyContext{
+			Discovery:  mock.Discovery,
+			Config:     mock.MakeRegistry(),
+			MeshConfig: &DefaultMeshConfig,
+			IPAddress:  mock.HostInstanceV0,
+		},
+		events: make(chan struct{}, 1),
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+
+	select {
+	case <-agent.configs:
+		t.Fatal("initial reload should wait for the controller to be ready")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	close(ctl.ready)
+	select {
+	case config := <-agent.configs:
+		if envoyConfig := config.(*Config); len(envoyConfig.Listeners) == 0 {
+			t.Errorf("initial reload should carry the registry state, got %#v", envoyConfig)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("initial reload did not happen after the controller became ready")
+	}
+
+	scheduleReload(w.events)
+	select {
+	case <-agent.configs:
+	case <-time.After(time.Second):
+		t.Fatal("event reload did not happen")
+	}
+}
+
+func TestWatcherReloadMetrics(t *testing.T) {
+	agent := &recordingAgent{configs: make(chan interface{}, 1)}
+	w := &watcher{
+		agent: agent,
+		ctl:   &mockController{},
+		context: &ProxyContext{
+			Discovery:  mock.Discovery,
+			Config:     mock.MakeRegistry(),
+			MeshConfig: &DefaultMeshConfig,
+			IPAddress:  mock.HostInstanceV0,
+		},
+	}
+	reloads := func() int64 {
+		if v, ok := watcherMetrics.Get("reloads").(*expvar.Int); ok {
+			return v.Value()
+		}
+		return 0
+	}
+	before := reloads()
+	w.reload()
+	<-agent.configs
+	if got := reloads(); got != before+1 {
+		t.Errorf("reloads => got %d, want %d", got, before+1)
+	}
+	if watcherMetrics.Get("last_generate_ms") == nil {
+		t.Error("last_generate_ms should be set after a reload")
+	}
+}
@@ -14,7 +14,11 @@
 
 package mock
 
-import "testing"
+import (
+	"testing"
+
+	"istio.io/manager/model"
+)
 
 func TestMockServices(t *testing.T) {
 	for _, svc := range Discovery.Services() {
@@ -32,3 +36,22 @@ func TestMockServices(t *testing.T) {
 		}
 	}
 }
+
+func TestMalformedServiceKeySelection(t *testing.T) {
+	cases := []struct {
+		key  string
+		want int
+	}{
+		{key: HelloService.Hostname + "|http", want: 2},
+		{key: HelloService.Hostname + "|http|version=v0", want: 1},
+		{key: HelloService.Hostname + "|http|=v0", want: 0},
+		{key: HelloService.Hostname + "|http|;", want: 0},
+		{key: HelloService.Hostname + "|http|=v0;version=v1", want: 1},
+	}
+	for _, c := range cases {
+		hostname, ports, tags := model.ParseServiceKey(c.key)
+		if got := Discovery.Instances(hostname, ports.GetNames(), tags); len(got) != c.want {
+			t.Errorf("Instances(%q) => got %d instances, want %d", c.key, len(got), c.want)
+		}
+	}
+}